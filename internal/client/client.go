@@ -1,32 +1,150 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/base32"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"hsync/internal/ignore"
+	"hsync/internal/merger"
 	"hsync/internal/protocol"
 	"hsync/internal/utils"
 	"io"
+	"io/fs"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/pelletier/go-toml/v2"
 )
 
+// debounceWindow is how long watchLocalChanges waits after the last
+// fsnotify event for a file before syncing it, so a burst of writes to
+// the same note collapses into a single upload.
+const debounceWindow = 300 * time.Millisecond
+
+// eventReconnectDelay is how long watchServerEvents waits before
+// retrying the SSE subscription after it drops.
+const eventReconnectDelay = 2 * time.Second
+
+// defaultSafetyNetInterval is how often the client falls back to a full
+// list+diff reconciliation when push notifications are working.
+const defaultSafetyNetInterval = 5 * time.Minute
+
+// hashAlgo is the digest algorithm this client negotiates with the
+// server, via Accept-Hash/X-Content-Hash, for end-to-end integrity
+// checks independent of transport-level ones.
+const hashAlgo = "sha256"
+
 // Config holds the client configuration
 type Config struct {
-	ServerURL          string `toml:"server"`
-	Key                string `toml:"key"`
-	DirPath            string `toml:"dir"`
+	ServerURL string `toml:"server"`
+	Key       string `toml:"key"`
+	DirPath   string `toml:"dir"`
+	// Interval is the period of the slower safety-net reconciliation
+	// that runs alongside the push-based sync; it no longer drives
+	// normal sync activity. Defaults to defaultSafetyNetInterval.
 	Interval           string `toml:"interval"`
 	InsecureSkipVerify bool   `toml:"insecureSkipVerify"`
+	// Include and Exclude add extra gitignore-style patterns on top of
+	// whatever .hsyncignore holds at the root of DirPath: Exclude
+	// patterns are appended as-is, Include patterns are appended as "!"
+	// negations so they win over a broader Exclude/.hsyncignore rule.
+	Include []string `toml:"include"`
+	Exclude []string `toml:"exclude"`
+	// AllowedExtensions restricts which file extensions are synced, e.g.
+	// []string{"txt", "md"}. Empty means allow anything not ignored.
+	AllowedExtensions []string `toml:"allowedExtensions"`
+	// DeviceID identifies this client in conflict filenames the server
+	// writes on an ambiguous merge. Generated on first run if empty and
+	// persisted back to the config file.
+	DeviceID string `toml:"deviceId"`
+	// Encrypt enables client-side AES-256-GCM encryption: the server
+	// only ever sees and hashes ciphertext, and merges move to the
+	// client since the server can no longer read what it would be
+	// merging. EncryptionKey is the base64-encoded 32-byte AES-256 key
+	// shared by every device syncing this directory.
+	Encrypt       bool   `toml:"encrypt"`
+	EncryptionKey string `toml:"encryptionKey"`
+	// SigningKey, if set, is this device's hex-encoded ed25519 private
+	// key (64-byte seed+public key): every plain (non-encrypted)
+	// full-body upload is signed with it, and the server stores the
+	// signature alongside the file as a "<file>.asc" sidecar when the
+	// upload lands unchanged.
+	SigningKey string `toml:"signingKey"`
+	// TrustedKeys is a keyring of hex-encoded ed25519 public keys: a
+	// downloaded file's sidecar signature is checked against every key
+	// here and rejected if none match. Empty disables verification.
+	TrustedKeys []string `toml:"trustedKeys"`
+}
+
+// syncFilter decides which files under a sync directory participate in
+// sync, combining .hsyncignore/Include/Exclude pattern matching with an
+// optional extension allowlist.
+type syncFilter struct {
+	ignoreMatcher *ignore.Matcher
+	allowedExts   map[string]struct{} // lowercased, with leading dot; empty means allow all
+}
+
+func buildSyncFilter(cfg *Config) *syncFilter {
+	var lines []string
+	data, err := os.ReadFile(filepath.Join(cfg.DirPath, ".hsyncignore"))
+	if err == nil {
+		lines = append(lines, strings.Split(string(data), "\n")...)
+	} else if !os.IsNotExist(err) {
+		log.Printf("Error reading .hsyncignore: %v", err)
+	}
+	lines = append(lines, cfg.Exclude...)
+	for _, inc := range cfg.Include {
+		lines = append(lines, "!"+inc)
+	}
+
+	exts := make(map[string]struct{}, len(cfg.AllowedExtensions))
+	for _, ext := range cfg.AllowedExtensions {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts[strings.ToLower(ext)] = struct{}{}
+	}
+
+	return &syncFilter{
+		ignoreMatcher: ignore.New(lines),
+		allowedExts:   exts,
+	}
+}
+
+// allowed reports whether relPath (slash-separated, relative to
+// cfg.DirPath) should participate in sync.
+func (f *syncFilter) allowed(relPath string, isDir bool) bool {
+	if f.ignoreMatcher.Match(relPath, isDir) {
+		return false
+	}
+	if isDir || len(f.allowedExts) == 0 {
+		return true
+	}
+	_, ok := f.allowedExts[strings.ToLower(filepath.Ext(relPath))]
+	return ok
+}
+
+// generateDeviceID returns 7 random base32 characters, suitable for
+// attributing a sync-conflict filename to this client.
+func generateDeviceID() (string, error) {
+	var buf [5]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf[:]))[:7], nil
 }
 
 func getDefaultDir() string {
@@ -46,9 +164,62 @@ func getDefaultDir() string {
 }
 
 var (
+	baseMu       sync.Mutex
 	baseContents = make(map[string]string)
+	// baseCipherHash tracks, per filename, the server-side ciphertext
+	// hash the local plaintext base in baseContents corresponds to. It's
+	// only used when Encrypt is on, since a plaintext base's own hash
+	// can't be compared against the ciphertext hashes the server lists.
+	baseCipherHash = make(map[string]string)
+	// baseCiphertext tracks, per filename, the verbatim ciphertext bytes
+	// (base64-encoded) the server is last known to hold for the local
+	// plaintext base. Only used when Encrypt is on: encryptContent seals
+	// with a fresh random nonce every call, so re-encrypting the same
+	// plaintext base would produce different ciphertext than what's
+	// already on disk server-side, and the server's fast-forward check
+	// in saveEncrypted compares raw ciphertext bytes, not plaintext.
+	baseCiphertext = make(map[string]string)
 )
 
+func getBaseContent(filename string) (string, bool) {
+	baseMu.Lock()
+	defer baseMu.Unlock()
+	content, ok := baseContents[filename]
+	return content, ok
+}
+
+func getBaseCipherHash(filename string) (string, bool) {
+	baseMu.Lock()
+	defer baseMu.Unlock()
+	hash, ok := baseCipherHash[filename]
+	return hash, ok
+}
+
+func setBaseCipherHash(filename, hash string) {
+	baseMu.Lock()
+	defer baseMu.Unlock()
+	baseCipherHash[filename] = hash
+}
+
+func setBaseContent(filename, content string) {
+	baseMu.Lock()
+	defer baseMu.Unlock()
+	baseContents[filename] = content
+}
+
+func getBaseCiphertext(filename string) (string, bool) {
+	baseMu.Lock()
+	defer baseMu.Unlock()
+	ciphertext, ok := baseCiphertext[filename]
+	return ciphertext, ok
+}
+
+func setBaseCiphertext(filename, ciphertext string) {
+	baseMu.Lock()
+	defer baseMu.Unlock()
+	baseCiphertext[filename] = ciphertext
+}
+
 func getHTTPClient(cfg *Config) *http.Client {
 	if cfg.InsecureSkipVerify {
 		tr := &http.Transport{
@@ -104,7 +275,7 @@ func Run(args []string) {
 
 	var interval time.Duration
 	if cfg.Interval == "" {
-		interval = 5 * time.Second
+		interval = defaultSafetyNetInterval
 	} else {
 		var err error
 		interval, err = time.ParseDuration(cfg.Interval)
@@ -113,6 +284,22 @@ func Run(args []string) {
 		}
 	}
 
+	if cfg.DeviceID == "" {
+		deviceID, err := generateDeviceID()
+		if err != nil {
+			log.Fatalf("Error generating device ID: %v", err)
+		}
+		cfg.DeviceID = deviceID
+
+		data, err := toml.Marshal(cfg)
+		if err != nil {
+			log.Fatalf("Error encoding config file: %v", err)
+		}
+		if err := os.WriteFile(configPath, data, 0644); err != nil {
+			log.Fatalf("Error persisting device ID to config file: %v", err)
+		}
+	}
+
 	// Ensure local dir exists
 	if err := os.MkdirAll(cfg.DirPath, 0755); err != nil {
 		log.Fatal(err)
@@ -125,20 +312,174 @@ func Run(args []string) {
 
 	httpClient := getHTTPClient(&cfg)
 
-	// 3-1. Initial Sync
+	// Initial full sync so we have a base for every existing file before
+	// switching to push-based updates.
 	syncWithServer(&cfg, httpClient)
+	checkAndUpload(&cfg, httpClient)
+
+	go watchServerEvents(&cfg, httpClient)
+	go watchLocalChanges(&cfg, httpClient)
 
+	// Slower safety net: catches anything a dropped SSE connection or a
+	// missed fsnotify event might have lost.
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		// Periodically check server for updates
 		syncWithServer(&cfg, httpClient)
-		// Check local changes
 		checkAndUpload(&cfg, httpClient)
 	}
 }
 
+// watchLocalChanges watches cfg.DirPath recursively for write/create/
+// rename events and uploads the affected file once its changes settle
+// for debounceWindow, so a burst of edits to one note becomes one
+// upload. Newly created subdirectories are picked up as they appear.
+func watchLocalChanges(cfg *Config, client *http.Client) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Error creating file watcher: %v", err)
+		return
+	}
+	defer watcher.Close()
+
+	filter := buildSyncFilter(cfg)
+
+	if err := addWatchesRecursively(watcher, cfg.DirPath, cfg.DirPath, filter); err != nil {
+		log.Printf("Error watching %s: %v", cfg.DirPath, err)
+		return
+	}
+
+	var timersMu sync.Mutex
+	timers := make(map[string]*time.Timer)
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			relPath := filepath.ToSlash(mustRel(cfg.DirPath, event.Name))
+
+			if info, statErr := os.Stat(event.Name); statErr == nil && info.IsDir() {
+				if event.Op&fsnotify.Create != 0 && filter.allowed(relPath, true) {
+					if err := addWatchesRecursively(watcher, cfg.DirPath, event.Name, filter); err != nil {
+						log.Printf("Error watching %s: %v", event.Name, err)
+					}
+				}
+				continue
+			}
+
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			if !filter.allowed(relPath, false) {
+				continue
+			}
+
+			timersMu.Lock()
+			if t, exists := timers[relPath]; exists {
+				t.Stop()
+			}
+			timers[relPath] = time.AfterFunc(debounceWindow, func() {
+				timersMu.Lock()
+				delete(timers, relPath)
+				timersMu.Unlock()
+				checkAndUploadFile(cfg, client, relPath)
+			})
+			timersMu.Unlock()
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Watcher error: %v", err)
+		}
+	}
+}
+
+// addWatchesRecursively registers a watch on start and every
+// non-ignored subdirectory beneath it, with paths evaluated against
+// filter relative to base.
+func addWatchesRecursively(watcher *fsnotify.Watcher, base, start string, filter *syncFilter) error {
+	return filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if p != base {
+			relPath := filepath.ToSlash(mustRel(base, p))
+			if filter.ignoreMatcher.Match(relPath, true) {
+				return filepath.SkipDir
+			}
+		}
+		if err := watcher.Add(p); err != nil {
+			log.Printf("Error watching %s: %v", p, err)
+		}
+		return nil
+	})
+}
+
+// mustRel is filepath.Rel without the error return, for use where base
+// is always an ancestor of target by construction.
+func mustRel(base, target string) string {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return target
+	}
+	return rel
+}
+
+// watchServerEvents subscribes to the server's SSE event stream and
+// reconciles the affected file whenever an event carries a hash this
+// client doesn't already hold, reconnecting with a fixed delay if the
+// stream drops.
+func watchServerEvents(cfg *Config, client *http.Client) {
+	for {
+		if err := streamServerEvents(cfg, client); err != nil {
+			log.Printf("Event stream error: %v", err)
+		}
+		time.Sleep(eventReconnectDelay)
+	}
+}
+
+func streamServerEvents(cfg *Config, client *http.Client) error {
+	req, err := http.NewRequest("GET", cfg.ServerURL+"/sync/events", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Sync-Key", cfg.Key)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var event protocol.Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			log.Printf("Error decoding event: %v", err)
+			continue
+		}
+		reconcileFile(cfg, client, event.Filename, event.Hash)
+	}
+	return scanner.Err()
+}
+
 func syncWithServer(cfg *Config, client *http.Client) {
 	// 1. Get List of Hashes
 	req, err := http.NewRequest("GET", cfg.ServerURL+"/sync", nil)
@@ -147,6 +488,7 @@ func syncWithServer(cfg *Config, client *http.Client) {
 		return
 	}
 	req.Header.Set("X-Sync-Key", cfg.Key)
+	req.Header.Set("Accept-Hash", hashAlgo)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -168,46 +510,113 @@ func syncWithServer(cfg *Config, client *http.Client) {
 
 	// 2. Compare and Download if needed
 	for filename, serverHash := range serverFiles {
-		localBaseContent, exists := baseContents[filename]
+		reconcileFile(cfg, client, filename, serverHash)
+	}
+}
 
-		// If we don't have it, or our base is outdated
-		if !exists || utils.CalculateHash(localBaseContent) != serverHash {
-			// Let's implement: Download content.
-			content, err := downloadFile(cfg, client, filename)
-			if err != nil {
-				log.Printf("Failed to download %s: %v", filename, err)
-				continue
-			}
+// reconcileFile brings the local copy of filename in line with the
+// server's current version identified by serverHash: downloads (or
+// delta-downloads) it and writes it locally if there are no local
+// changes in the way, deferring to the next upload otherwise. It's the
+// shared endpoint for both the periodic full reconciliation and
+// targeted updates triggered by a server-sent event.
+func reconcileFile(cfg *Config, client *http.Client, filename, serverHash string) {
+	localBaseContent, exists := getBaseContent(filename)
+	if cfg.Encrypt {
+		if cipherHash, ok := getBaseCipherHash(filename); ok && cipherHash == serverHash {
+			return
+		}
+	} else if exists && utils.CalculateHash(localBaseContent) == serverHash {
+		return
+	}
 
-			// Update base
-			baseContents[filename] = content
-
-			// Update local file IF it was clean (same as old base)
-			localPath := filepath.Join(cfg.DirPath, filename)
-			currentBytes, err := os.ReadFile(localPath)
-			if os.IsNotExist(err) {
-				// File doesn't exist locally, just write it
-				os.WriteFile(localPath, []byte(content), 0644)
-				log.Printf("Downloaded new file: %s", filename)
-			} else if err == nil {
-				if exists && string(currentBytes) == localBaseContent {
-					// Local was clean, safe to update
-					os.WriteFile(localPath, []byte(content), 0644)
-					log.Printf("Updated file from server: %s", filename)
-				} else {
-					log.Printf("Skipping download for %s (local changes detected). Will attempt merge via upload.", filename)
-				}
-			}
+	content, err := downloadFile(cfg, client, filename, localBaseContent, exists)
+	if err != nil {
+		log.Printf("Failed to download %s: %v", filename, err)
+		return
+	}
+
+	setBaseContent(filename, content)
+	if cfg.Encrypt {
+		setBaseCipherHash(filename, serverHash)
+	}
+
+	// Update local file IF it was clean (same as old base)
+	localPath := filepath.Join(cfg.DirPath, filepath.FromSlash(filename))
+	currentBytes, err := os.ReadFile(localPath)
+	if os.IsNotExist(err) {
+		// File doesn't exist locally, just write it
+		os.MkdirAll(filepath.Dir(localPath), 0755)
+		os.WriteFile(localPath, []byte(content), 0644)
+		log.Printf("Downloaded new file: %s", filename)
+	} else if err == nil {
+		if exists && string(currentBytes) == localBaseContent {
+			// Local was clean, safe to update
+			os.WriteFile(localPath, []byte(content), 0644)
+			log.Printf("Updated file from server: %s", filename)
+		} else {
+			log.Printf("Skipping download for %s (local changes detected). Will attempt merge via upload.", filename)
 		}
 	}
 }
 
-func downloadFile(cfg *Config, client *http.Client, filename string) (string, error) {
+// downloadFile fetches filename's current content from the server. When
+// haveBase is true and the local base is large enough to be worth it,
+// it instead submits a signature of haveBaseContent and reconstructs
+// the content from the returned Delta, avoiding a full-body transfer.
+// Encrypted directories always fetch in full: a stored ciphertext
+// carries a fresh random nonce on every write, so its rolling checksum
+// never matches an older version's and a delta would never hit.
+func downloadFile(cfg *Config, client *http.Client, filename, haveBaseContent string, haveBase bool) (string, error) {
+	content, err := downloadFileContent(cfg, client, filename, haveBaseContent, haveBase)
+	if err != nil {
+		return "", err
+	}
+
+	// Signature verification is opt-in and applies regardless of which
+	// path above produced content, since a delta reconstruction and a
+	// full transfer are both reproducing the exact same server-side
+	// bytes the signature was made over.
+	if !cfg.Encrypt && len(cfg.TrustedKeys) > 0 {
+		if err := verifySidecarSignature(cfg, client, filename, []byte(content)); err != nil {
+			return "", fmt.Errorf("signature check failed for %s: %w", filename, err)
+		}
+	}
+	return content, nil
+}
+
+func downloadFileContent(cfg *Config, client *http.Client, filename, haveBaseContent string, haveBase bool) (string, error) {
+	if !cfg.Encrypt && haveBase && len(haveBaseContent) > protocol.BlockSize {
+		content, err := downloadFileDelta(cfg, client, filename, haveBaseContent)
+		if err == nil {
+			return content, nil
+		}
+		log.Printf("Delta download failed for %s, falling back to full transfer: %v", filename, err)
+	}
+	return downloadFileFull(cfg, client, filename)
+}
+
+// downloadFileFull fetches filename's current content from the server,
+// resuming from a ".part" file left over from an interrupted download
+// instead of starting over from byte zero: the server's Range support
+// means a lost connection only costs the bytes already on disk.
+func downloadFileFull(cfg *Config, client *http.Client, filename string) (string, error) {
+	partPath := filepath.Join(cfg.DirPath, filepath.FromSlash(filename)+".part")
+
+	var existing []byte
+	if data, err := os.ReadFile(partPath); err == nil {
+		existing = data
+	}
+
 	req, err := http.NewRequest("GET", cfg.ServerURL+"/sync?filename="+filename, nil)
 	if err != nil {
 		return "", err
 	}
 	req.Header.Set("X-Sync-Key", cfg.Key)
+	req.Header.Set("Accept-Hash", hashAlgo)
+	if len(existing) > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(existing)))
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -215,91 +624,518 @@ func downloadFile(cfg *Config, client *http.Client, filename string) (string, er
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Server didn't honor our Range: it's sending the whole file.
+		existing = nil
+	case http.StatusPartialContent:
+		// Resuming from len(existing) as requested.
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Our partial data no longer lines up with the server's file
+		// (e.g. it shrank); discard it and restart from scratch.
+		os.Remove(partPath)
+		return downloadFileFull(cfg, client, filename)
+	default:
 		return "", fmt.Errorf("status %d", resp.StatusCode)
 	}
 
-	data, err := io.ReadAll(resp.Body)
+	rest, readErr := io.ReadAll(resp.Body)
+	existing = append(existing, rest...)
+	if readErr != nil {
+		if err := os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
+			log.Printf("Error creating directory for partial download of %s: %v", filename, err)
+		} else if err := os.WriteFile(partPath, existing, 0644); err != nil {
+			log.Printf("Error saving partial download for %s: %v", filename, err)
+		}
+		return "", readErr
+	}
+
+	// The assembled content is now complete (whether this response was a
+	// 200 or the tail of a resumed 206), so verify it end-to-end against
+	// the server's X-Content-Hash before trusting it.
+	if algo, digest, ok := utils.ParseContentHash(resp.Header.Get("X-Content-Hash")); ok {
+		if utils.HasherFor(algo).Sum(string(existing)) != digest {
+			os.Remove(partPath)
+			return "", fmt.Errorf("content hash mismatch for %s", filename)
+		}
+	}
+	os.Remove(partPath)
+
+	if cfg.Encrypt {
+		key, err := loadEncryptionKey(cfg)
+		if err != nil {
+			return "", err
+		}
+		plaintext, err := decryptContent(key, filename, string(existing))
+		if err != nil {
+			return "", err
+		}
+		// Cache the verbatim ciphertext alongside the plaintext it
+		// decrypts to, so a later upload of this same base can resend it
+		// as-is instead of re-encrypting the plaintext under a new nonce.
+		setBaseCiphertext(filename, string(existing))
+		return plaintext, nil
+	}
+	return string(existing), nil
+}
+
+// downloadFileDelta submits the signature of the client's own base
+// content and reconstructs the server's current content by replaying
+// the returned Delta against it.
+func downloadFileDelta(cfg *Config, client *http.Client, filename, baseContent string) (string, error) {
+	sig := protocol.BuildSignature(filename, []byte(baseContent))
+	jsonBody, err := json.Marshal(sig)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", cfg.ServerURL+"/sync/signature", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Sync-Key", cfg.Key)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", err
 	}
-	return string(data), nil
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var delta protocol.Delta
+	if err := json.NewDecoder(resp.Body).Decode(&delta); err != nil {
+		return "", err
+	}
+	return string(protocol.ApplyDelta(delta, []byte(baseContent), protocol.BlockSize)), nil
 }
 
+// checkAndUpload walks cfg.DirPath recursively and uploads every file
+// allowed by cfg's ignore rules and extension allowlist.
 func checkAndUpload(cfg *Config, client *http.Client) {
-	entries, err := os.ReadDir(cfg.DirPath)
+	filter := buildSyncFilter(cfg)
+
+	err := filepath.WalkDir(cfg.DirPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if p == cfg.DirPath {
+			return nil
+		}
+		relPath := filepath.ToSlash(mustRel(cfg.DirPath, p))
+		if d.IsDir() {
+			if filter.ignoreMatcher.Match(relPath, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !filter.allowed(relPath, false) {
+			return nil
+		}
+		checkAndUploadFile(cfg, client, relPath)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error walking directory: %v", err)
+	}
+}
+
+// checkAndUploadFile uploads filename if its on-disk content has
+// diverged from the last base we synced, used both by the periodic full
+// reconciliation and by watchLocalChanges for a single changed file.
+func checkAndUploadFile(cfg *Config, client *http.Client, filename string) {
+	localPath := filepath.Join(cfg.DirPath, filepath.FromSlash(filename))
+	contentBytes, err := os.ReadFile(localPath)
 	if err != nil {
-		log.Printf("Error reading directory: %v", err)
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading %s: %v", filename, err)
+		}
 		return
 	}
+	currentContent := string(contentBytes)
 
-	for _, entry := range entries {
-		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".txt") {
-			continue
+	base, exists := getBaseContent(filename)
+	if !exists {
+		// New file detected
+		base = ""
+	}
+
+	if currentContent == base {
+		return // No change
+	}
+
+	log.Printf("File changed: %s", filename)
+	syncFile(cfg, client, filename, base, currentContent)
+}
+
+// syncFile uploads a local change to the server. When there's an
+// existing base larger than one block, it prefers a delta upload
+// (fetching the server's signature and sending only what differs) and
+// falls back to a full-body POST for new or small files, or if the
+// delta path fails for any reason.
+func syncFile(cfg *Config, client *http.Client, filename, base, current string) {
+	if cfg.Encrypt {
+		resp, err := postEncrypted(cfg, client, filename, base, current)
+		if err != nil {
+			log.Printf("Upload failed for %s: %v", filename, err)
+			return
+		}
+		applySyncResponse(cfg, filename, current, resp)
+		return
+	}
+
+	if base != "" && len(base) > protocol.BlockSize {
+		resp, err := postDelta(cfg, client, filename, base, current)
+		if err == nil {
+			applySyncResponse(cfg, filename, current, resp)
+			return
+		}
+		log.Printf("Delta upload failed for %s, falling back to full transfer: %v", filename, err)
+	}
+
+	resp, err := postFull(cfg, client, filename, base, current)
+	if err != nil {
+		log.Printf("Upload failed for %s: %v", filename, err)
+		return
+	}
+	applySyncResponse(cfg, filename, current, resp)
+}
+
+func postFull(cfg *Config, client *http.Client, filename, base, current string) (*protocol.SyncResponse, error) {
+	// A large file travels as multipart parts instead, which the server
+	// spools to disk rather than decoding into a protocol.SyncRequest's
+	// strings. The request body itself is still assembled in memory here.
+	if len(current) > protocol.LargeFileThreshold {
+		return postFullMultipart(cfg, client, filename, base, current)
+	}
+
+	signature, err := buildSignature(cfg, current)
+	if err != nil {
+		return nil, err
+	}
+
+	reqBody := protocol.SyncRequest{
+		Filename:  filename,
+		Base:      base,
+		Latest:    current,
+		Signature: signature,
+	}
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	contentHash := hashAlgo + "=" + utils.HasherFor(hashAlgo).Sum(current)
+	return doSyncPost(cfg, client, cfg.ServerURL+"/sync", jsonBody, contentHash)
+}
+
+// postFullMultipart is postFull's counterpart for uploads above
+// protocol.LargeFileThreshold: it sends base/current as multipart parts
+// (built in an in-memory buffer) instead of a single JSON body, and reads the server's
+// response as a raw merged body (with its integrity and conflict
+// metadata carried in headers, mirroring downloadFileFull) instead of a
+// protocol.SyncResponse, for the same reason.
+func postFullMultipart(cfg *Config, client *http.Client, filename, base, current string) (*protocol.SyncResponse, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	signature, err := buildSignature(cfg, current)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := map[string]string{
+		"filename":    filename,
+		"contentHash": hashAlgo + "=" + utils.HasherFor(hashAlgo).Sum(current),
+	}
+	if signature != "" {
+		meta["signature"] = signature
+	}
+
+	var metaPart, basePart, latestPart io.Writer
+	if metaPart, err = mw.CreateFormField("meta"); err == nil {
+		err = json.NewEncoder(metaPart).Encode(meta)
+	}
+	if err == nil {
+		if basePart, err = mw.CreateFormFile("base", filename); err == nil {
+			_, err = basePart.Write([]byte(base))
+		}
+	}
+	if err == nil {
+		if latestPart, err = mw.CreateFormFile("latest", filename); err == nil {
+			_, err = latestPart.Write([]byte(current))
 		}
+	}
+	if err == nil {
+		err = mw.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		filename := entry.Name()
-		localPath := filepath.Join(cfg.DirPath, filename)
-		contentBytes, err := os.ReadFile(localPath)
+	req, err := http.NewRequest("POST", cfg.ServerURL+"/sync", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Sync-Key", cfg.Key)
+	req.Header.Set("X-Sync-Device", cfg.DeviceID)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	merged, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if algo, digest, ok := utils.ParseContentHash(resp.Header.Get("X-Content-Hash")); ok {
+		if utils.HasherFor(algo).Sum(string(merged)) != digest {
+			return nil, fmt.Errorf("content hash mismatch for %s", filename)
+		}
+	}
+
+	return &protocol.SyncResponse{
+		Synced:       string(merged),
+		ConflictFile: resp.Header.Get("X-Conflict-File"),
+	}, nil
+}
+
+// postEncrypted uploads filename's change under encryption: base and
+// current are sealed client-side before they ever reach the server.
+// Since the server can't read ciphertext to merge it, it instead
+// replies 409 with its current content whenever base has moved; this
+// decrypts that response, merges locally with merger.ThreeWayMerge, and
+// retries with the fresh base, up to a few times.
+func postEncrypted(cfg *Config, client *http.Client, filename, base, current string) (*protocol.SyncResponse, error) {
+	key, err := loadEncryptionKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// encBase starts as the verbatim ciphertext the server is last known
+	// to hold for base (cached from the last accepted upload or
+	// download), not a fresh encryption of it: encryptContent seals with
+	// a random nonce every call, so re-encrypting the same plaintext base
+	// here would send different ciphertext bytes than what's already on
+	// disk server-side, which would fail saveEncrypted's fast-forward
+	// check -- and therefore 409 -- on every upload after the very first.
+	// Falling back to a fresh encryption is only correct for a base the
+	// client has never synced before (e.g. a brand-new file), where the
+	// server has no prior ciphertext for base to compare against anyway.
+	encBase, haveCachedBase := getBaseCiphertext(filename)
+	if !haveCachedBase {
+		encBase, err = encryptContent(key, filename, base)
 		if err != nil {
-			log.Printf("Error reading %s: %v", filename, err)
-			continue
+			return nil, err
+		}
+	}
+
+	const maxConflictRetries = 3
+	for attempt := 0; ; attempt++ {
+		encCurrent, err := encryptContent(key, filename, current)
+		if err != nil {
+			return nil, err
+		}
+		jsonBody, err := json.Marshal(protocol.SyncRequest{
+			Filename:  filename,
+			Base:      encBase,
+			Latest:    encCurrent,
+			Encrypted: true,
+		})
+		if err != nil {
+			return nil, err
 		}
-		currentContent := string(contentBytes)
 
-		base, exists := baseContents[filename]
-		if !exists {
-			// New file detected
-			base = ""
+		contentHash := hashAlgo + "=" + utils.HasherFor(hashAlgo).Sum(encCurrent)
+		resp, conflictCiphertext, err := doEncryptedSyncPost(cfg, client, filename, cfg.ServerURL+"/sync", jsonBody, contentHash)
+		if err != nil {
+			return nil, err
 		}
 
-		if currentContent == base {
-			continue // No change
+		if resp != nil {
+			setBaseCiphertext(filename, resp.Synced)
+			plaintext, err := decryptContent(key, filename, resp.Synced)
+			if err != nil {
+				return nil, err
+			}
+			resp.Synced = plaintext
+			return resp, nil
 		}
 
-		log.Printf("File changed: %s", filename)
-		syncFile(cfg, client, filename, base, currentContent)
+		if attempt >= maxConflictRetries {
+			return nil, fmt.Errorf("too many conflicting retries for %s", filename)
+		}
+		serverPlaintext, err := decryptContent(key, filename, conflictCiphertext)
+		if err != nil {
+			return nil, err
+		}
+		merged, _, err := merger.ThreeWayMerge(base, current, serverPlaintext)
+		if err != nil {
+			return nil, err
+		}
+		// conflictCiphertext is already the server's verbatim ciphertext
+		// for serverPlaintext, so it becomes the next attempt's encBase
+		// directly instead of being re-encrypted.
+		base, current = serverPlaintext, merged
+		encBase = conflictCiphertext
 	}
 }
 
-func syncFile(cfg *Config, client *http.Client, filename, base, current string) {
-	reqBody := protocol.SyncRequest{
+// doEncryptedSyncPost is doSyncPost's counterpart for Encrypted
+// requests: a 409 means the server's content has moved past base, and
+// its ciphertext comes back as conflictCiphertext instead of an error,
+// so postEncrypted can merge and retry instead of giving up.
+func doEncryptedSyncPost(cfg *Config, client *http.Client, filename, url string, jsonBody []byte, contentHash string) (syncResp *protocol.SyncResponse, conflictCiphertext string, err error) {
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("X-Sync-Key", cfg.Key)
+	req.Header.Set("X-Sync-Device", cfg.DeviceID)
+	req.Header.Set("Content-Type", "application/json")
+	if contentHash != "" {
+		req.Header.Set("X-Content-Hash", contentHash)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		var conflictResp protocol.ConflictResponse
+		if err := json.NewDecoder(resp.Body).Decode(&conflictResp); err != nil {
+			return nil, "", err
+		}
+		return nil, conflictResp.ServerContent, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, "", fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result protocol.SyncResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", err
+	}
+
+	// Stash the raw ciphertext hash before the caller overwrites Synced
+	// with the decrypted plaintext, since that's what the server lists
+	// and broadcasts hashes against.
+	setBaseCipherHash(filename, utils.CalculateHash(result.Synced))
+
+	return &result, "", nil
+}
+
+func postDelta(cfg *Config, client *http.Client, filename, base, current string) (*protocol.SyncResponse, error) {
+	sig, err := fetchSignature(cfg, client, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	reqBody := protocol.DeltaUploadRequest{
 		Filename: filename,
 		Base:     base,
-		Latest:   current,
+		Delta:    protocol.BuildDelta(filename, []byte(current), sig),
+	}
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
 	}
-	jsonBody, _ := json.Marshal(reqBody)
 
-	req, err := http.NewRequest("POST", cfg.ServerURL+"/sync", bytes.NewBuffer(jsonBody))
+	// /sync/delta reconstructs Latest server-side from the delta rather
+	// than receiving it verbatim, so there's no client-supplied content
+	// to hash here; the hash check is specific to the full-body /sync
+	// upload.
+	return doSyncPost(cfg, client, cfg.ServerURL+"/sync/delta", jsonBody, "")
+}
+
+func fetchSignature(cfg *Config, client *http.Client, filename string) (protocol.Signature, error) {
+	req, err := http.NewRequest("GET", cfg.ServerURL+"/sync/signature?filename="+filename, nil)
 	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		return
+		return protocol.Signature{}, err
 	}
 	req.Header.Set("X-Sync-Key", cfg.Key)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return protocol.Signature{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return protocol.Signature{}, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var sig protocol.Signature
+	if err := json.NewDecoder(resp.Body).Decode(&sig); err != nil {
+		return protocol.Signature{}, err
+	}
+	return sig, nil
+}
+
+// doSyncPost POSTs jsonBody to url. contentHash, if non-empty, is sent as
+// X-Content-Hash so the server can reject a tampered or corrupted body
+// before touching disk; pass "" for endpoints that don't require it.
+func doSyncPost(cfg *Config, client *http.Client, url string, jsonBody []byte, contentHash string) (*protocol.SyncResponse, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Sync-Key", cfg.Key)
+	req.Header.Set("X-Sync-Device", cfg.DeviceID)
 	req.Header.Set("Content-Type", "application/json")
+	if contentHash != "" {
+		req.Header.Set("X-Content-Hash", contentHash)
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Printf("Upload failed for %s: %v", filename, err)
-		return
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		log.Printf("Upload failed for %s (status %d): %s", filename, resp.StatusCode, string(body))
-		return
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
 	}
 
 	var syncResp protocol.SyncResponse
 	if err := json.NewDecoder(resp.Body).Decode(&syncResp); err != nil {
-		log.Printf("Error decoding response for %s: %v", filename, err)
-		return
+		return nil, err
+	}
+	return &syncResp, nil
+}
+
+// applySyncResponse writes the server's merged content back to disk
+// when it differs from what was uploaded, and updates the in-memory
+// base either way.
+func applySyncResponse(cfg *Config, filename, current string, syncResp *protocol.SyncResponse) {
+	if syncResp.ConflictFile != "" {
+		log.Printf("Conflicting edit to %s: keeping server's version, saving yours as %s", filename, syncResp.ConflictFile)
+		conflictPath := filepath.Join(cfg.DirPath, filepath.FromSlash(syncResp.ConflictFile))
+		if err := os.MkdirAll(filepath.Dir(conflictPath), 0755); err != nil {
+			log.Printf("Error creating directory for conflict file %s: %v", syncResp.ConflictFile, err)
+		} else if err := os.WriteFile(conflictPath, []byte(current), 0644); err != nil {
+			log.Printf("Error writing conflict file %s: %v", syncResp.ConflictFile, err)
+		}
 	}
 
-	// Update local file and base
 	if syncResp.Synced != current {
-		localPath := filepath.Join(cfg.DirPath, filename)
+		localPath := filepath.Join(cfg.DirPath, filepath.FromSlash(filename))
 		if err := os.WriteFile(localPath, []byte(syncResp.Synced), 0644); err != nil {
 			log.Printf("Error writing merged file %s: %v", filename, err)
 			return
@@ -309,5 +1145,5 @@ func syncFile(cfg *Config, client *http.Client, filename, base, current string)
 		log.Printf("Upload for %s complete (no merge conflicts).", filename)
 	}
 
-	baseContents[filename] = syncResp.Synced
+	setBaseContent(filename, syncResp.Synced)
 }
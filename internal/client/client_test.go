@@ -0,0 +1,258 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hsync/internal/protocol"
+	"hsync/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestDownloadFileFullResumesAfterTruncatedTransfer simulates a
+// connection that drops partway through a download: the first response
+// is hijacked and cut off mid-body, then the second attempt is served
+// for real. downloadFileFull should persist what it got as a ".part"
+// file and resume from there instead of starting over.
+func TestDownloadFileFullResumesAfterTruncatedTransfer(t *testing.T) {
+	content := strings.Repeat("line of synced note content\n", 200)
+	truncateAt := len(content) / 3
+
+	var attempts int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sync", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("filename") != "note.txt" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			hj, ok := w.(http.Hijacker)
+			if !ok {
+				http.Error(w, "hijack unsupported", http.StatusInternalServerError)
+				return
+			}
+			conn, buf, err := hj.Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\n\r\n", len(content))
+			buf.WriteString(content[:truncateAt])
+			buf.Flush()
+			return
+		}
+
+		http.ServeContent(w, r, "note.txt", time.Now(), strings.NewReader(content))
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	cfg := &Config{ServerURL: ts.URL, Key: "test-key", DirPath: t.TempDir()}
+
+	if _, err := downloadFileFull(cfg, http.DefaultClient, "note.txt"); err == nil {
+		t.Fatal("expected first (truncated) download to fail")
+	}
+
+	partPath := filepath.Join(cfg.DirPath, "note.txt.part")
+	partial, err := os.ReadFile(partPath)
+	if err != nil {
+		t.Fatalf("expected partial download to be saved: %v", err)
+	}
+	if len(partial) != truncateAt {
+		t.Fatalf("saved partial = %d bytes, want %d", len(partial), truncateAt)
+	}
+
+	got, err := downloadFileFull(cfg, http.DefaultClient, "note.txt")
+	if err != nil {
+		t.Fatalf("resumed download failed: %v", err)
+	}
+	if got != content {
+		t.Fatalf("resumed content mismatch: got %d bytes, want %d", len(got), len(content))
+	}
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Errorf(".part file should be removed after a successful resume, stat err = %v", err)
+	}
+	if atomic.LoadInt32(&attempts) != 2 {
+		t.Fatalf("expected exactly 2 requests, got %d", attempts)
+	}
+}
+
+// TestPostFullMultipartUpload checks that postFullMultipart - the path
+// postFull takes for uploads above protocol.LargeFileThreshold -
+// parses a raw-body response with its conflict metadata in headers back
+// into the same *protocol.SyncResponse shape postFull's JSON path
+// returns, so callers like applySyncResponse don't need to know which
+// path served them.
+func TestPostFullMultipartUpload(t *testing.T) {
+	const merged = "server's current content"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sync", func(w http.ResponseWriter, r *http.Request) {
+		if mediaType := r.Header.Get("Content-Type"); !strings.HasPrefix(mediaType, "multipart/") {
+			t.Errorf("Content-Type = %q, want multipart/form-data", mediaType)
+		}
+		w.Header().Set("X-Content-Hash", "sha256="+utils.HasherFor("sha256").Sum(merged))
+		w.Header().Set("X-Conflict-File", "note.sync-conflict-20260726-150405-abc1234.txt")
+		w.Write([]byte(merged))
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	cfg := &Config{ServerURL: ts.URL, Key: "test-key", DeviceID: "abc1234"}
+
+	resp, err := postFullMultipart(cfg, http.DefaultClient, "note.txt", "base content", "current content")
+	if err != nil {
+		t.Fatalf("postFullMultipart: %v", err)
+	}
+	if resp.Synced != merged {
+		t.Errorf("Synced = %q, want %q", resp.Synced, merged)
+	}
+	if resp.ConflictFile != "note.sync-conflict-20260726-150405-abc1234.txt" {
+		t.Errorf("ConflictFile = %q, want the server's conflict sibling path", resp.ConflictFile)
+	}
+}
+
+// TestPostFullSignsUploadWhenConfigured checks that postFull attaches an
+// armored ed25519 Signature over the uploaded content when cfg.SigningKey
+// is set, and omits it entirely when it isn't.
+func TestPostFullSignsUploadWhenConfigured(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	var gotSignature string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sync", func(w http.ResponseWriter, r *http.Request) {
+		var req protocol.SyncRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		gotSignature = req.Signature
+		json.NewEncoder(w).Encode(protocol.SyncResponse{Synced: req.Latest})
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	const content = "note content to sign"
+	cfg := &Config{ServerURL: ts.URL, Key: "test-key", SigningKey: hex.EncodeToString(priv)}
+	if _, err := postFull(cfg, http.DefaultClient, "note.txt", "", content); err != nil {
+		t.Fatalf("postFull: %v", err)
+	}
+	if gotSignature == "" {
+		t.Fatal("expected a Signature to be sent, got none")
+	}
+	sig, err := protocol.DearmorSignature(gotSignature)
+	if err != nil {
+		t.Fatalf("dearmor signature: %v", err)
+	}
+	if !ed25519.Verify(pub, []byte(content), sig) {
+		t.Error("signature does not verify against the signing key's public half")
+	}
+
+	cfg.SigningKey = ""
+	if _, err := postFull(cfg, http.DefaultClient, "note.txt", "", content); err != nil {
+		t.Fatalf("postFull: %v", err)
+	}
+	if gotSignature != "" {
+		t.Errorf("Signature = %q, want none when SigningKey is unset", gotSignature)
+	}
+}
+
+// TestDownloadFileRejectsUnverifiedSignature checks that downloadFile
+// fails when cfg.TrustedKeys is configured and the server's sidecar
+// signature doesn't verify against any of them.
+func TestDownloadFileRejectsUnverifiedSignature(t *testing.T) {
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	const content = "note content"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sync", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != "" {
+			w.Write([]byte(protocol.ArmorSignature(ed25519.Sign(otherPriv, []byte(content)))))
+			return
+		}
+		w.Write([]byte(content))
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	cfg := &Config{ServerURL: ts.URL, Key: "test-key", DirPath: t.TempDir(), TrustedKeys: []string{hex.EncodeToString(pub)}}
+	if _, err := downloadFile(cfg, http.DefaultClient, "note.txt", "", false); err == nil {
+		t.Fatal("expected an error from an untrusted signature")
+	}
+}
+
+// TestPostEncryptedConvergesAcrossEdits drives postEncrypted through an
+// upload, then an edit of that same file, against a fake server that
+// reproduces saveEncrypted's fast-forward check: a request's Base is
+// accepted only if its ciphertext hash matches what's stored. Before the
+// base-ciphertext cache fix, postEncrypted re-encrypted the same
+// plaintext base under a fresh random nonce on every call, so its
+// ciphertext hash could never match what the server had actually stored
+// from the prior upload -- every edit after the first would hit the
+// server's 409 path and exhaust postEncrypted's retries without ever
+// converging.
+func TestPostEncryptedConvergesAcrossEdits(t *testing.T) {
+	var stored string // server's stored ciphertext for note.txt
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sync", func(w http.ResponseWriter, r *http.Request) {
+		var req protocol.SyncRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		if stored != "" && utils.CalculateHash(stored) != utils.CalculateHash(req.Base) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(protocol.ConflictResponse{ServerContent: stored})
+			return
+		}
+		stored = req.Latest
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(protocol.SyncResponse{Synced: stored})
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	key := make([]byte, 32)
+	cfg := &Config{
+		ServerURL:     ts.URL,
+		Key:           "test-key",
+		Encrypt:       true,
+		EncryptionKey: base64.StdEncoding.EncodeToString(key),
+	}
+
+	resp, err := postEncrypted(cfg, http.DefaultClient, "encrypted-note.txt", "", "first version")
+	if err != nil {
+		t.Fatalf("first upload: %v", err)
+	}
+	if resp.Synced != "first version" {
+		t.Fatalf("Synced = %q, want %q", resp.Synced, "first version")
+	}
+
+	resp, err = postEncrypted(cfg, http.DefaultClient, "encrypted-note.txt", "first version", "second version")
+	if err != nil {
+		t.Fatalf("second upload (edit): %v", err)
+	}
+	if resp.Synced != "second version" {
+		t.Fatalf("Synced = %q, want %q", resp.Synced, "second version")
+	}
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// gcmNonceSize is the standard AES-GCM nonce length.
+const gcmNonceSize = 12
+
+// loadEncryptionKey decodes cfg.EncryptionKey into the 32-byte key
+// AES-256-GCM requires.
+func loadEncryptionKey(cfg *Config) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(cfg.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryptionKey: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, errors.New("encryptionKey must decode to 32 bytes for AES-256")
+	}
+	return key, nil
+}
+
+// encryptContent seals plaintext under key, using filename as
+// additional authenticated data so a file's ciphertext can't be
+// replayed by the server under a different name. The random nonce is
+// prepended to the ciphertext and the result base64-encoded, since the
+// sync protocol carries content as JSON strings.
+func encryptContent(key []byte, filename, plaintext string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcmNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(filename))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptContent reverses encryptContent, rejecting the ciphertext if
+// filename doesn't match the additional data it was sealed with.
+func decryptContent(key []byte, filename, encoded string) (string, error) {
+	if encoded == "" {
+		return "", nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcmNonceSize {
+		return "", errors.New("ciphertext too short")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, ciphertext := sealed[:gcmNonceSize], sealed[gcmNonceSize:]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(filename))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,160 @@
+package client
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	return []byte("01234567890123456789012345678901"[:32])
+}
+
+// TestEncryptDecryptContentRoundTrips checks that decryptContent
+// reverses encryptContent for the same key and filename.
+func TestEncryptDecryptContentRoundTrips(t *testing.T) {
+	key := testKey(t)
+	const plaintext = "note content to encrypt"
+
+	ciphertext, err := encryptContent(key, "note.txt", plaintext)
+	if err != nil {
+		t.Fatalf("encryptContent: %v", err)
+	}
+	if ciphertext == plaintext {
+		t.Fatal("ciphertext should not equal the plaintext")
+	}
+
+	got, err := decryptContent(key, "note.txt", ciphertext)
+	if err != nil {
+		t.Fatalf("decryptContent: %v", err)
+	}
+	if got != plaintext {
+		t.Errorf("decrypted = %q, want %q", got, plaintext)
+	}
+}
+
+// TestEncryptContentUsesFreshNonceEachCall checks that encrypting the
+// same plaintext twice produces different ciphertext, so a passive
+// observer can't tell two uploads carry the same content.
+func TestEncryptContentUsesFreshNonceEachCall(t *testing.T) {
+	key := testKey(t)
+	a, err := encryptContent(key, "note.txt", "same content")
+	if err != nil {
+		t.Fatalf("encryptContent: %v", err)
+	}
+	b, err := encryptContent(key, "note.txt", "same content")
+	if err != nil {
+		t.Fatalf("encryptContent: %v", err)
+	}
+	if a == b {
+		t.Error("two encryptions of the same plaintext should differ by nonce")
+	}
+}
+
+// TestDecryptContentRejectsFilenameMismatch checks that ciphertext
+// sealed for one filename is rejected when decrypted under another,
+// since filename is authenticated as additional data.
+func TestDecryptContentRejectsFilenameMismatch(t *testing.T) {
+	key := testKey(t)
+	ciphertext, err := encryptContent(key, "note.txt", "secret content")
+	if err != nil {
+		t.Fatalf("encryptContent: %v", err)
+	}
+
+	if _, err := decryptContent(key, "renamed.txt", ciphertext); err == nil {
+		t.Fatal("expected decryption to fail when filename doesn't match the sealed AAD")
+	}
+}
+
+// TestDecryptContentRejectsTamperedCiphertext checks that flipping a
+// byte of the ciphertext (not just the nonce or tag) is caught by GCM's
+// authentication rather than silently producing garbage plaintext.
+func TestDecryptContentRejectsTamperedCiphertext(t *testing.T) {
+	key := testKey(t)
+	encoded, err := encryptContent(key, "note.txt", "secret content")
+	if err != nil {
+		t.Fatalf("encryptContent: %v", err)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decode ciphertext: %v", err)
+	}
+	sealed[gcmNonceSize] ^= 0xFF // first ciphertext byte, after the nonce
+	tampered := base64.StdEncoding.EncodeToString(sealed)
+
+	if _, err := decryptContent(key, "note.txt", tampered); err == nil {
+		t.Fatal("expected decryption to fail for tampered ciphertext")
+	}
+}
+
+// TestDecryptContentRejectsWrongKey checks that ciphertext sealed under
+// one key doesn't decrypt under another.
+func TestDecryptContentRejectsWrongKey(t *testing.T) {
+	key := testKey(t)
+	ciphertext, err := encryptContent(key, "note.txt", "secret content")
+	if err != nil {
+		t.Fatalf("encryptContent: %v", err)
+	}
+
+	otherKey := append([]byte(nil), key...)
+	otherKey[0] ^= 0xFF
+	if _, err := decryptContent(otherKey, "note.txt", ciphertext); err == nil {
+		t.Fatal("expected decryption to fail under the wrong key")
+	}
+}
+
+// TestDecryptContentEmptyStringIsEmptyPlaintext checks decryptContent's
+// special case for an empty encoded string (a file with no prior
+// ciphertext base), which returns empty plaintext rather than erroring.
+func TestDecryptContentEmptyStringIsEmptyPlaintext(t *testing.T) {
+	key := testKey(t)
+	got, err := decryptContent(key, "note.txt", "")
+	if err != nil {
+		t.Fatalf("decryptContent: %v", err)
+	}
+	if got != "" {
+		t.Errorf("decryptContent(\"\") = %q, want empty", got)
+	}
+}
+
+// TestDecryptContentRejectsShortCiphertext checks that ciphertext too
+// short to even hold a nonce is rejected instead of panicking on a
+// slice out of range.
+func TestDecryptContentRejectsShortCiphertext(t *testing.T) {
+	key := testKey(t)
+	short := base64.StdEncoding.EncodeToString([]byte("short"))
+	if _, err := decryptContent(key, "note.txt", short); err == nil {
+		t.Fatal("expected an error for ciphertext shorter than the nonce")
+	}
+}
+
+// TestLoadEncryptionKeyValidatesSize checks that loadEncryptionKey
+// rejects a base64-valid key that doesn't decode to AES-256's 32 bytes,
+// and accepts one that does.
+func TestLoadEncryptionKeyValidatesSize(t *testing.T) {
+	tooShort := base64.StdEncoding.EncodeToString([]byte("short key"))
+	if _, err := loadEncryptionKey(&Config{EncryptionKey: tooShort}); err == nil {
+		t.Fatal("expected an error for a key that doesn't decode to 32 bytes")
+	}
+
+	valid := base64.StdEncoding.EncodeToString(testKey(t))
+	key, err := loadEncryptionKey(&Config{EncryptionKey: valid})
+	if err != nil {
+		t.Fatalf("loadEncryptionKey: %v", err)
+	}
+	if len(key) != 32 {
+		t.Errorf("len(key) = %d, want 32", len(key))
+	}
+}
+
+// TestLoadEncryptionKeyRejectsInvalidBase64 checks that a malformed
+// base64 EncryptionKey is reported rather than passed through.
+func TestLoadEncryptionKeyRejectsInvalidBase64(t *testing.T) {
+	if _, err := loadEncryptionKey(&Config{EncryptionKey: "not-valid-base64!!"}); err == nil {
+		t.Fatal("expected an error for invalid base64")
+	} else if !strings.Contains(err.Error(), "encryptionKey") {
+		t.Errorf("error = %q, want it to mention encryptionKey", err)
+	}
+}
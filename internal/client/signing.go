@@ -0,0 +1,100 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"hsync/internal/protocol"
+	"io"
+	"net/http"
+)
+
+// loadSigningKey decodes cfg.SigningKey into the ed25519 private key
+// uploads are signed with.
+func loadSigningKey(cfg *Config) (ed25519.PrivateKey, error) {
+	raw, err := hex.DecodeString(cfg.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signingKey: %w", err)
+	}
+	if len(raw) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("signingKey must decode to %d bytes", ed25519.PrivateKeySize)
+	}
+	return ed25519.PrivateKey(raw), nil
+}
+
+// loadTrustedKeys decodes cfg.TrustedKeys into the ed25519 public keys a
+// downloaded signature sidecar is checked against.
+func loadTrustedKeys(cfg *Config) ([]ed25519.PublicKey, error) {
+	keys := make([]ed25519.PublicKey, 0, len(cfg.TrustedKeys))
+	for _, hexKey := range cfg.TrustedKeys {
+		raw, err := hex.DecodeString(hexKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trustedKeys entry %q: %w", hexKey, err)
+		}
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("trustedKeys entry %q must decode to %d bytes", hexKey, ed25519.PublicKeySize)
+		}
+		keys = append(keys, ed25519.PublicKey(raw))
+	}
+	return keys, nil
+}
+
+// buildSignature returns an armored ed25519 signature over content using
+// cfg.SigningKey, or "" if signing isn't configured.
+func buildSignature(cfg *Config, content string) (string, error) {
+	if cfg.SigningKey == "" {
+		return "", nil
+	}
+	key, err := loadSigningKey(cfg)
+	if err != nil {
+		return "", err
+	}
+	return protocol.ArmorSignature(ed25519.Sign(key, []byte(content))), nil
+}
+
+// verifySidecarSignature fetches filename's detached signature sidecar
+// and checks it against content using cfg.TrustedKeys. A 404 means the
+// server holds no signature for this file, which isn't itself an error:
+// signing is opt-in, so a file uploaded unsigned (by another device, or
+// before this one ever configured a signingKey) is expected to have
+// none.
+func verifySidecarSignature(cfg *Config, client *http.Client, filename string, content []byte) error {
+	req, err := http.NewRequest("GET", cfg.ServerURL+"/sync?filename="+filename+"&sig=1", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Sync-Key", cfg.Key)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d fetching signature", resp.StatusCode)
+	}
+
+	armored, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	sig, err := protocol.DearmorSignature(string(armored))
+	if err != nil {
+		return fmt.Errorf("malformed signature: %w", err)
+	}
+
+	keys, err := loadTrustedKeys(cfg)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, content, sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no trusted key matches the signature for %s", filename)
+}
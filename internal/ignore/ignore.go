@@ -0,0 +1,155 @@
+// Package ignore implements a practical subset of gitignore pattern
+// matching: literal and glob line patterns, "!" negation, "**" globs,
+// and directory-only patterns with a trailing "/".
+package ignore
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// Matcher evaluates a sequence of compiled patterns against
+// slash-separated paths relative to some root.
+type Matcher struct {
+	rules []rule
+}
+
+type rule struct {
+	negate   bool
+	dirOnly  bool
+	anchored bool // pattern contains a "/" before its last segment, so it only matches relative to the root
+	pattern  string
+}
+
+// New compiles patterns, one per line, as found in a .hsyncignore file.
+// Blank lines and lines starting with "#" are skipped.
+func New(lines []string) *Matcher {
+	m := &Matcher{}
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		r := rule{}
+		if strings.HasPrefix(trimmed, "!") {
+			r.negate = true
+			trimmed = trimmed[1:]
+		}
+		if strings.HasSuffix(trimmed, "/") {
+			r.dirOnly = true
+			trimmed = strings.TrimSuffix(trimmed, "/")
+		}
+		trimmed = strings.TrimPrefix(trimmed, "/")
+		r.anchored = strings.Contains(trimmed, "/")
+		r.pattern = trimmed
+
+		m.rules = append(m.rules, r)
+	}
+	return m
+}
+
+// Load reads a gitignore-style file at filePath. A missing file yields
+// a Matcher that ignores nothing rather than an error.
+func Load(filePath string) (*Matcher, error) {
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return New(nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return New(strings.Split(string(data), "\n")), nil
+}
+
+// Match reports whether relPath (slash-separated, relative to the sync
+// root) is ignored. isDir indicates whether relPath names a directory.
+// As in gitignore, the last matching rule wins.
+func (m *Matcher) Match(relPath string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	ignored := false
+	for _, r := range m.rules {
+		if matchRule(r, relPath, isDir) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+func matchRule(r rule, relPath string, isDir bool) bool {
+	if r.dirOnly && !isDir && !dirAncestorMatches(r, relPath) {
+		return false
+	}
+
+	segments := strings.Split(relPath, "/")
+
+	if r.anchored {
+		if matchSegments(r.pattern, relPath) {
+			return true
+		}
+		return r.dirOnly && dirAncestorMatches(r, relPath)
+	}
+
+	for i := range segments {
+		if matchSegments(r.pattern, strings.Join(segments[i:], "/")) {
+			return true
+		}
+	}
+	return r.dirOnly && dirAncestorMatches(r, relPath)
+}
+
+// dirAncestorMatches reports whether a directory-only pattern matches
+// one of relPath's parent directories, so files nested under an ignored
+// directory are ignored too.
+func dirAncestorMatches(r rule, relPath string) bool {
+	segments := strings.Split(relPath, "/")
+	for i := 1; i < len(segments); i++ {
+		ancestor := strings.Join(segments[:i], "/")
+		if r.anchored {
+			if matchSegments(r.pattern, ancestor) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(r.pattern, segments[i-1]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, candidate string) bool {
+	if strings.Contains(pattern, "**") {
+		return matchGlobstar(strings.Split(pattern, "/"), strings.Split(candidate, "/"))
+	}
+	ok, _ := path.Match(pattern, candidate)
+	return ok
+}
+
+// matchGlobstar matches pattern segments against candidate segments,
+// treating a "**" segment as zero or more segments.
+func matchGlobstar(pattern, candidate []string) bool {
+	if len(pattern) == 0 {
+		return len(candidate) == 0
+	}
+	if pattern[0] == "**" {
+		if matchGlobstar(pattern[1:], candidate) {
+			return true
+		}
+		if len(candidate) == 0 {
+			return false
+		}
+		return matchGlobstar(pattern, candidate[1:])
+	}
+	if len(candidate) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], candidate[0]); !ok {
+		return false
+	}
+	return matchGlobstar(pattern[1:], candidate[1:])
+}
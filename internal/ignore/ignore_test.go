@@ -0,0 +1,139 @@
+package ignore
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestMatchLiteralAndGlob checks plain literal and single-segment glob
+// patterns against both matching and non-matching paths.
+func TestMatchLiteralAndGlob(t *testing.T) {
+	m := New([]string{"*.log", "build"})
+
+	if !m.Match("debug.log", false) {
+		t.Error("debug.log should match *.log")
+	}
+	if m.Match("debug.log.txt", false) {
+		t.Error("debug.log.txt should not match *.log")
+	}
+	if !m.Match("build", true) {
+		t.Error("build should match the unanchored literal \"build\"")
+	}
+	if !m.Match("src/build", true) {
+		t.Error("an unanchored pattern should match at any depth")
+	}
+}
+
+// TestMatchNegationReinstatesLaterFiles checks that a later "!" rule
+// reinstates a path an earlier rule ignored, and that ordering matters:
+// the last matching rule wins.
+func TestMatchNegationReinstatesLaterFiles(t *testing.T) {
+	m := New([]string{"*.log", "!important.log"})
+
+	if !m.Match("debug.log", false) {
+		t.Error("debug.log should still be ignored")
+	}
+	if m.Match("important.log", false) {
+		t.Error("important.log should be reinstated by the negation rule")
+	}
+}
+
+// TestMatchDirOnlyIgnoresNestedFiles checks that a trailing-"/" pattern
+// matches the named directory and everything nested under it, but not a
+// file that merely shares its name.
+func TestMatchDirOnlyIgnoresNestedFiles(t *testing.T) {
+	m := New([]string{"node_modules/"})
+
+	if !m.Match("node_modules", true) {
+		t.Error("node_modules itself should match")
+	}
+	if m.Match("node_modules", false) {
+		t.Error("a file named node_modules should not match a dir-only pattern")
+	}
+	if !m.Match("node_modules/pkg/index.js", false) {
+		t.Error("files nested under an ignored directory should be ignored")
+	}
+}
+
+// TestMatchAnchoredPatternOnlyMatchesAtThatPath checks that a pattern
+// containing an internal "/" is anchored relative to the sync root and
+// doesn't also match the same name reappearing at a different depth.
+func TestMatchAnchoredPatternOnlyMatchesAtThatPath(t *testing.T) {
+	m := New([]string{"sub/config.toml"})
+
+	if !m.Match("sub/config.toml", false) {
+		t.Error("sub/config.toml should match")
+	}
+	if m.Match("other/sub/config.toml", false) {
+		t.Error("an anchored pattern should not match config.toml nested under a different parent")
+	}
+	if m.Match("config.toml", false) {
+		t.Error("an anchored pattern should not match the bare filename at the root")
+	}
+}
+
+// TestMatchGlobstarMatchesAnyDepth checks that "**" spans zero or more
+// path segments, both as a prefix and in the middle of a pattern.
+func TestMatchGlobstarMatchesAnyDepth(t *testing.T) {
+	m := New([]string{"**/*.tmp", "cache/**/data"})
+
+	if !m.Match("tmp/a.tmp", false) {
+		t.Error("a.tmp under tmp/ should match **/*.tmp")
+	}
+	if !m.Match("deeply/nested/dir/a.tmp", false) {
+		t.Error("a.tmp nested several levels deep should match **/*.tmp")
+	}
+	if !m.Match("a.tmp", false) {
+		t.Error("** should also match zero segments, so a.tmp at the root should match")
+	}
+
+	if !m.Match("cache/data", false) {
+		t.Error("cache/**/data should match with ** spanning zero segments")
+	}
+	if !m.Match("cache/x/y/data", false) {
+		t.Error("cache/**/data should match with ** spanning multiple segments")
+	}
+	if m.Match("cache/data/extra", false) {
+		t.Error("cache/**/data should not match a path with extra trailing segments")
+	}
+}
+
+// TestMatchEmptyMatcherIgnoresNothing checks that a Matcher built from no
+// rules, and a nil Matcher, both report nothing as ignored.
+func TestMatchEmptyMatcherIgnoresNothing(t *testing.T) {
+	m := New(nil)
+	if m.Match("anything.txt", false) {
+		t.Error("an empty Matcher should not ignore anything")
+	}
+
+	var nilMatcher *Matcher
+	if nilMatcher.Match("anything.txt", false) {
+		t.Error("a nil Matcher should not ignore anything")
+	}
+}
+
+// TestMatchIgnoresCommentsAndBlankLines checks that New skips blank
+// lines and "#"-prefixed comment lines instead of compiling them into
+// rules.
+func TestMatchIgnoresCommentsAndBlankLines(t *testing.T) {
+	m := New([]string{"", "  ", "# a comment", "*.log"})
+
+	if len(m.rules) != 1 {
+		t.Fatalf("expected exactly 1 compiled rule, got %d", len(m.rules))
+	}
+	if !m.Match("debug.log", false) {
+		t.Error("the remaining *.log rule should still match")
+	}
+}
+
+// TestLoadMissingFileIgnoresNothing checks that Load treats a missing
+// .hsyncignore as an empty rule set rather than an error.
+func TestLoadMissingFileIgnoresNothing(t *testing.T) {
+	m, err := Load(filepath.Join(t.TempDir(), "does-not-exist"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if m.Match("anything.txt", false) {
+		t.Error("a missing ignore file should ignore nothing")
+	}
+}
@@ -1,21 +1,100 @@
 package merger
 
 import (
+	"io"
+	"strings"
+
 	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
-// ThreeWayMerge merges the changes from base to client into server.
-// It returns the merged text.
-func ThreeWayMerge(base, client, server string) (string, error) {
+// lineDiffThreshold is the combined input size above which
+// ThreeWayMergeReaders switches diffmatchpatch into line mode, so its
+// O(N^2) patch cost is paid per line rather than per byte.
+const lineDiffThreshold = 1 << 20 // 1 MiB
+
+// ThreeWayMerge merges the changes from base to client into server. It
+// returns the merged text and whether any hunk failed to apply cleanly
+// (Conflicted), meaning the merge is only a best-effort approximation
+// and the caller should not trust it as a silent resolution.
+func ThreeWayMerge(base, client, server string) (string, bool, error) {
 	dmp := diffmatchpatch.New()
 
 	// 1. Calculate patches: how did client change from base?
 	patches := dmp.PatchMake(base, client)
 
 	// 2. Apply patches to server version
-	merged, _ := dmp.PatchApply(patches, server)
+	merged, results := dmp.PatchApply(patches, server)
+
+	conflicted := false
+	for _, ok := range results {
+		if !ok {
+			conflicted = true
+			break
+		}
+	}
+
+	return merged, conflicted, nil
+}
+
+// ThreeWayMergeReaders behaves like ThreeWayMerge but takes its three
+// inputs as readers, so a caller spooling large uploads to disk can pass
+// open files straight through instead of first materializing them as
+// HTTP-request-lifetime strings. It still reads all three inputs fully
+// into memory before diffing, though: diffmatchpatch has no streaming
+// API, so this is a disk-sourced merge, not a bounded-memory one. The
+// large-file line-mode fallback (threeWayMergeLines) only bounds its
+// O(N^2) patch-time cost, not the memory this function holds onto. The
+// result is returned as a stream only in the sense that it's wrapped in
+// an io.ReadCloser for the caller's convenience.
+func ThreeWayMergeReaders(base, client, server io.Reader) (merged io.ReadCloser, conflicted bool, err error) {
+	baseBytes, err := io.ReadAll(base)
+	if err != nil {
+		return nil, false, err
+	}
+	clientBytes, err := io.ReadAll(client)
+	if err != nil {
+		return nil, false, err
+	}
+	serverBytes, err := io.ReadAll(server)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var result string
+	if len(baseBytes)+len(clientBytes)+len(serverBytes) > lineDiffThreshold {
+		result, conflicted, err = threeWayMergeLines(string(baseBytes), string(clientBytes), string(serverBytes))
+	} else {
+		result, conflicted, err = ThreeWayMerge(string(baseBytes), string(clientBytes), string(serverBytes))
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return io.NopCloser(strings.NewReader(result)), conflicted, nil
+}
+
+// threeWayMergeLines is ThreeWayMerge's large-file path: it diffs base
+// against client in line mode (diffmatchpatch's DiffLinesToChars collapses
+// each line to a single rune, so the character-level diff underneath runs
+// over line count, not byte count) and expands the result back to full
+// text before building patches to apply to server.
+func threeWayMergeLines(base, client, server string) (string, bool, error) {
+	dmp := diffmatchpatch.New()
+
+	baseChars, clientChars, lineArray := dmp.DiffLinesToChars(base, client)
+	diffs := dmp.DiffMain(baseChars, clientChars, false)
+	diffs = dmp.DiffCharsToLines(diffs, lineArray)
+
+	patches := dmp.PatchMake(base, diffs)
+	merged, results := dmp.PatchApply(patches, server)
+
+	conflicted := false
+	for _, ok := range results {
+		if !ok {
+			conflicted = true
+			break
+		}
+	}
 
-	// merged is the string, results is []bool indicating success/fail of patches
-	// For this simple sync, we'll accept the best-effort merge.
-	return merged, nil
+	return merged, conflicted, nil
 }
@@ -0,0 +1,151 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// adlerMod is the modulus used by the weak rolling checksum, matching
+// the one Adler-32 uses.
+const adlerMod = 65521
+
+// BuildSignature splits content into fixed BlockSize blocks and computes
+// a weak rolling checksum plus a truncated strong hash for each one.
+func BuildSignature(filename string, content []byte) Signature {
+	sig := Signature{Filename: filename, BlockSize: BlockSize}
+	for i := 0; i < len(content); i += BlockSize {
+		end := i + BlockSize
+		if end > len(content) {
+			end = len(content)
+		}
+		block := content[i:end]
+		a, b := rollingChecksum(block)
+		sig.Blocks = append(sig.Blocks, BlockSignature{
+			Index:  i / BlockSize,
+			Weak:   a + b<<16,
+			Strong: strongHash(block),
+		})
+	}
+	return sig
+}
+
+// rollingChecksum computes the two Adler-32-style components of block
+// from scratch: a is the sum of bytes mod M, b is the weighted sum.
+func rollingChecksum(block []byte) (a, b uint32) {
+	n := uint32(len(block))
+	for i, c := range block {
+		a = (a + uint32(c)) % adlerMod
+		b = (b + (n-uint32(i))*uint32(c)) % adlerMod
+	}
+	return a, b
+}
+
+func strongHash(block []byte) []byte {
+	sum := sha256.Sum256(block)
+	return sum[:16]
+}
+
+// BuildDelta slides a byte window over content, maintaining the rolling
+// checksum in O(1) per shift, and emits a Delta that reconstructs
+// content when replayed against the blocks sig was built from: literal
+// bytes where nothing matched, block references where they did.
+func BuildDelta(filename string, content []byte, sig Signature) Delta {
+	blockSize := sig.BlockSize
+	if blockSize == 0 {
+		blockSize = BlockSize
+	}
+
+	byWeak := make(map[uint32][]BlockSignature, len(sig.Blocks))
+	for _, blk := range sig.Blocks {
+		byWeak[blk.Weak] = append(byWeak[blk.Weak], blk)
+	}
+
+	delta := Delta{Filename: filename}
+	var literal []byte
+	flushLiteral := func() {
+		if len(literal) > 0 {
+			delta.Ops = append(delta.Ops, DeltaOp{Literal: literal})
+			literal = nil
+		}
+	}
+
+	n := len(content)
+	i := 0
+	var a, b uint32
+	haveWindow := false
+
+	for i < n {
+		end := i + blockSize
+		if end > n {
+			end = n
+		}
+		window := content[i:end]
+
+		if len(window) == blockSize {
+			if !haveWindow {
+				a, b = rollingChecksum(window)
+				haveWindow = true
+			}
+
+			if candidates, ok := byWeak[a+b<<16]; ok {
+				strong := strongHash(window)
+				for _, blk := range candidates {
+					if bytes.Equal(blk.Strong, strong) {
+						flushLiteral()
+						idx := blk.Index
+						delta.Ops = append(delta.Ops, DeltaOp{BlockIndex: &idx})
+						i += blockSize
+						haveWindow = false
+						goto advanced
+					}
+				}
+			}
+		}
+
+		// No match at this offset: emit the leading byte as a literal
+		// and slide the window forward by one, updating the rolling
+		// checksum incrementally instead of recomputing it.
+		literal = append(literal, content[i])
+		if haveWindow {
+			out := uint32(window[0])
+			b = (b + adlerMod - (uint32(len(window))*out)%adlerMod) % adlerMod
+			a = (a + adlerMod - out) % adlerMod
+			if i+blockSize < n {
+				in := uint32(content[i+blockSize])
+				a = (a + in) % adlerMod
+				b = (b + a) % adlerMod
+			} else {
+				haveWindow = false
+			}
+		}
+		i++
+
+	advanced:
+	}
+	flushLiteral()
+	return delta
+}
+
+// ApplyDelta reconstructs the remote content by replaying delta against
+// ownContent, the data the peer's Signature was computed from.
+func ApplyDelta(delta Delta, ownContent []byte, blockSize int) []byte {
+	if blockSize == 0 {
+		blockSize = BlockSize
+	}
+	var out bytes.Buffer
+	for _, op := range delta.Ops {
+		if op.BlockIndex != nil {
+			start := *op.BlockIndex * blockSize
+			end := start + blockSize
+			if end > len(ownContent) {
+				end = len(ownContent)
+			}
+			if start < len(ownContent) {
+				out.Write(ownContent[start:end])
+			}
+			continue
+		}
+		out.Write(op.Literal)
+	}
+	return out.Bytes()
+}
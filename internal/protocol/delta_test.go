@@ -0,0 +1,187 @@
+package protocol
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// roundTrip builds a signature for ownContent, a delta turning it into
+// newContent, and replays that delta against ownContent, asserting the
+// result reconstructs newContent exactly.
+func roundTrip(t *testing.T, ownContent, newContent string) {
+	t.Helper()
+	sig := BuildSignature("f", []byte(ownContent))
+	delta := BuildDelta("f", []byte(newContent), sig)
+	got := ApplyDelta(delta, []byte(ownContent), sig.BlockSize)
+	if string(got) != newContent {
+		t.Fatalf("ApplyDelta = %q, want %q", got, newContent)
+	}
+}
+
+// TestBuildDeltaRoundTripsUnchangedContent checks that content identical
+// to the signature's source reconstructs byte-for-byte as pure block
+// references, with no literal bytes needed.
+func TestBuildDeltaRoundTripsUnchangedContent(t *testing.T) {
+	content := strings.Repeat("abcdefgh", BlockSize/4) // two full blocks
+	roundTrip(t, content, content)
+
+	sig := BuildSignature("f", []byte(content))
+	delta := BuildDelta("f", []byte(content), sig)
+	for _, op := range delta.Ops {
+		if op.Literal != nil {
+			t.Fatalf("unexpected literal op %q for unchanged content", op.Literal)
+		}
+	}
+}
+
+// TestBuildDeltaRoundTripsExactBlockBoundary checks reconstruction when
+// newContent is exactly one block boundary shorter or longer than the
+// signature's source, the edges BuildSignature's "end > len(content)"
+// clamp and BuildDelta's window-sizing both have to get right.
+func TestBuildDeltaRoundTripsExactBlockBoundary(t *testing.T) {
+	base := strings.Repeat("x", BlockSize*3)
+	roundTrip(t, base, base[:BlockSize*2])
+	roundTrip(t, base, base+strings.Repeat("y", BlockSize))
+}
+
+// TestBuildDeltaRoundTripsInsertionShiftsWindow checks that inserting
+// bytes before a run of otherwise-unchanged blocks still finds those
+// blocks: the rolling window no longer lands on the original block
+// boundary, so this exercises the incremental checksum update in
+// BuildDelta's no-match path rather than the fast path.
+func TestBuildDeltaRoundTripsInsertionShiftsWindow(t *testing.T) {
+	tail := strings.Repeat("0123456789abcdef", BlockSize/8)
+	own := "prefix-" + tail
+	edited := "a different and longer prefix inserted here-" + tail
+	roundTrip(t, own, edited)
+
+	sig := BuildSignature("f", []byte(own))
+	delta := BuildDelta("f", []byte(edited), sig)
+	var sawBlockRef bool
+	for _, op := range delta.Ops {
+		if op.BlockIndex != nil {
+			sawBlockRef = true
+		}
+	}
+	if !sawBlockRef {
+		t.Fatal("expected the shifted tail to still be found as a block reference")
+	}
+}
+
+// TestBuildDeltaRoundTripsDeletion checks reconstruction when a middle
+// block-sized span is deleted, so blocks on either side of the deletion
+// are unaffected but no longer contiguous.
+func TestBuildDeltaRoundTripsDeletion(t *testing.T) {
+	first := strings.Repeat("A", BlockSize)
+	middle := strings.Repeat("B", BlockSize)
+	last := strings.Repeat("C", BlockSize)
+	own := first + middle + last
+	edited := first + last
+	roundTrip(t, own, edited)
+}
+
+// TestBuildDeltaRoundTripsEmptyContent checks the degenerate cases of an
+// empty signature source, an empty edit, and both empty.
+func TestBuildDeltaRoundTripsEmptyContent(t *testing.T) {
+	roundTrip(t, "", "")
+	roundTrip(t, "", "new content with no prior blocks to reference")
+	roundTrip(t, "some prior content", "")
+}
+
+// TestBuildDeltaRoundTripsContentSmallerThanBlockSize checks content
+// shorter than a single block, which BuildSignature still emits one
+// (partial) block for.
+func TestBuildDeltaRoundTripsContentSmallerThanBlockSize(t *testing.T) {
+	roundTrip(t, "short", "short but edited")
+	roundTrip(t, "short base", "short")
+}
+
+// TestBuildSignatureBlockIndexesAndSizes checks that BuildSignature
+// emits a trailing partial block as a block of its own, indexes blocks
+// by position, and records BlockSize in the Signature.
+func TestBuildSignatureBlockIndexesAndSizes(t *testing.T) {
+	content := []byte(strings.Repeat("z", BlockSize+10))
+	sig := BuildSignature("f", content)
+	if len(sig.Blocks) != 2 {
+		t.Fatalf("expected 2 blocks, got %d", len(sig.Blocks))
+	}
+	if sig.Blocks[0].Index != 0 || sig.Blocks[1].Index != 1 {
+		t.Fatalf("block indexes = %d, %d, want 0, 1", sig.Blocks[0].Index, sig.Blocks[1].Index)
+	}
+	if sig.BlockSize != BlockSize {
+		t.Fatalf("Signature.BlockSize = %d, want %d", sig.BlockSize, BlockSize)
+	}
+}
+
+// TestApplyDeltaHandlesBlockIndexPastOwnContent checks that ApplyDelta
+// doesn't panic or overrun when a Delta references a block index beyond
+// what ownContent actually holds (e.g. stale signature after a
+// truncation): it should just emit whatever overlap exists, empty if
+// none.
+func TestApplyDeltaHandlesBlockIndexPastOwnContent(t *testing.T) {
+	idx := 5
+	delta := Delta{Filename: "f", Ops: []DeltaOp{{BlockIndex: &idx}}}
+	got := ApplyDelta(delta, []byte("short"), BlockSize)
+	if len(got) != 0 {
+		t.Fatalf("ApplyDelta = %q, want empty for an out-of-range block", got)
+	}
+}
+
+// TestRollingChecksumMatchesFullRecompute checks that the incremental
+// checksum update BuildDelta performs as its window slides forward by
+// one byte produces the same (a, b) pair as recomputing rollingChecksum
+// from scratch over the shifted window -- the property the "easy to get
+// subtly wrong on off-by-one window boundaries" risk is about.
+func TestRollingChecksumMatchesFullRecompute(t *testing.T) {
+	content := []byte("the quick brown fox jumps over the lazy dog, then jumps back again")
+	const window = 8
+	if len(content) <= window {
+		t.Fatal("test fixture too short")
+	}
+
+	a, b := rollingChecksum(content[0:window])
+	for i := 0; i+window+1 <= len(content); i++ {
+		out := uint32(content[i])
+		b = (b + adlerMod - (uint32(window)*out)%adlerMod) % adlerMod
+		a = (a + adlerMod - out) % adlerMod
+		in := uint32(content[i+window])
+		a = (a + in) % adlerMod
+		b = (b + a) % adlerMod
+
+		wantA, wantB := rollingChecksum(content[i+1 : i+1+window])
+		if a != wantA || b != wantB {
+			t.Fatalf("incremental checksum at shift %d = (%d, %d), want (%d, %d)", i+1, a, b, wantA, wantB)
+		}
+	}
+}
+
+// TestBuildDeltaRequiresStrongHashMatch checks that a weak-checksum hit
+// alone isn't enough to emit a block reference: BuildDelta must also
+// confirm the strong hash before trusting a weak match. It forges a
+// signature whose weak checksum is correct but whose strong hash isn't,
+// and confirms content identical to the original block is still encoded
+// as a literal rather than a (wrong) block reference.
+func TestBuildDeltaRequiresStrongHashMatch(t *testing.T) {
+	block := []byte("exactly one block's worth of content, repeated so it reaches the block size. ")
+	for len(block) < BlockSize {
+		block = append(block, block...)
+	}
+	block = block[:BlockSize]
+
+	sig := BuildSignature("f", block)
+	sig.Blocks[0].Strong = append([]byte(nil), sig.Blocks[0].Strong...)
+	sig.Blocks[0].Strong[0] ^= 0xFF // same weak checksum, wrong strong hash
+
+	delta := BuildDelta("f", block, sig)
+	for _, op := range delta.Ops {
+		if op.BlockIndex != nil {
+			t.Fatal("a weak-checksum-only match must not be emitted as a block reference")
+		}
+	}
+
+	reconstructed := ApplyDelta(delta, block, sig.BlockSize)
+	if !bytes.Equal(reconstructed, block) {
+		t.Fatalf("reconstructed = %q, want %q", reconstructed, block)
+	}
+}
@@ -1,11 +1,95 @@
 package protocol
 
+// BlockSize is the fixed block size, in bytes, used for delta transfer.
+// Files at or below this size are always synced in full.
+const BlockSize = 2048
+
+// LargeFileThreshold is the content size above which the client uploads
+// a full-body sync as multipart parts instead of a single
+// protocol.SyncRequest, so the server can spool the parts to disk
+// instead of decoding them into in-memory strings.
+const LargeFileThreshold = 1 << 20 // 1 MiB
+
 type SyncRequest struct {
 	Filename string `json:"filename"`
 	Base     string `json:"base"`
 	Latest   string `json:"latest"`
+	// Encrypted marks Base and Latest as ciphertext the server cannot
+	// read: it skips its own 3-way merge and instead responds 409 with
+	// a ConflictResponse whenever its stored hash has moved past Base,
+	// so the client can decrypt both sides and merge locally.
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Signature, if set, is an armored ed25519 signature over Latest;
+	// the server verifies it against its configured trusted keys and,
+	// when the merge lands Latest unchanged, stores it as a
+	// "<file>.asc" sidecar.
+	Signature string `json:"signature,omitempty"`
 }
 
 type SyncResponse struct {
 	Synced string `json:"synced"`
+	// ConflictFile is set when the merge was ambiguous: it names the
+	// sibling file the server wrote the client's rejected Latest to,
+	// relative to the sync root, so the client can materialize the same
+	// conflict copy locally instead of silently discarding it.
+	ConflictFile string `json:"conflictFile,omitempty"`
+}
+
+// ConflictResponse is the HTTP 409 body for an Encrypted SyncRequest
+// whose Base no longer matches what the server holds: it carries the
+// server's current content (ciphertext) so the client can merge it
+// locally and retry, since the server has no way to merge ciphertext
+// itself.
+type ConflictResponse struct {
+	ServerContent string `json:"serverContent"`
+}
+
+// BlockSignature is the rolling and strong checksum pair for one
+// fixed-size block of a file.
+type BlockSignature struct {
+	Index  int    `json:"index"`
+	Weak   uint32 `json:"weak"`
+	Strong []byte `json:"strong"`
+}
+
+// Signature describes a file as held by one side of a sync, as a
+// sequence of per-block checksums, so the other side can compute a
+// delta against it without transferring the whole file.
+type Signature struct {
+	Filename  string           `json:"filename"`
+	BlockSize int              `json:"blockSize"`
+	Blocks    []BlockSignature `json:"blocks"`
+}
+
+// DeltaOp is a single instruction in a Delta stream: either a literal
+// run of bytes absent from the peer's signature, or a reference to one
+// of its existing blocks.
+type DeltaOp struct {
+	Literal    []byte `json:"literal,omitempty"`
+	BlockIndex *int   `json:"blockIndex,omitempty"`
+}
+
+// Delta is the ordered list of operations that reconstructs a file when
+// replayed against the content the Signature was built from.
+type Delta struct {
+	Filename string    `json:"filename"`
+	Ops      []DeltaOp `json:"ops"`
+}
+
+// DeltaUploadRequest carries an upload expressed as a Delta against the
+// server's own signature, rather than the full Latest body. Base is
+// still sent in full since the 3-way merge needs it for comparison, not
+// just reconstruction.
+type DeltaUploadRequest struct {
+	Filename string `json:"filename"`
+	Base     string `json:"base"`
+	Delta    Delta  `json:"delta"`
+}
+
+// Event is broadcast over the SSE event stream whenever a file's
+// content changes on the server, so subscribers can fetch it instead of
+// waiting for their next periodic reconciliation.
+type Event struct {
+	Filename string `json:"filename"`
+	Hash     string `json:"hash"`
 }
\ No newline at end of file
@@ -0,0 +1,59 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+)
+
+// TestArmorDearmorSignatureRoundTrips checks that DearmorSignature
+// reverses ArmorSignature for a real ed25519 signature.
+func TestArmorDearmorSignatureRoundTrips(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	sig := ed25519.Sign(priv, []byte("content"))
+
+	armored := ArmorSignature(sig)
+	if !strings.Contains(armored, SignatureArmorHeader) || !strings.Contains(armored, SignatureArmorFooter) {
+		t.Fatalf("armored signature missing hsync armor markers: %q", armored)
+	}
+
+	got, err := DearmorSignature(armored)
+	if err != nil {
+		t.Fatalf("DearmorSignature: %v", err)
+	}
+	if string(got) != string(sig) {
+		t.Errorf("dearmored signature doesn't match the original")
+	}
+}
+
+// TestDearmorSignatureRejectsGPGArmor checks that a GPG-armored
+// signature block is rejected with an explicit mismatch error instead
+// of an opaque base64 decode failure, since hsync's armor format isn't
+// GPG-compatible.
+func TestDearmorSignatureRejectsGPGArmor(t *testing.T) {
+	gpgBlock := "-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE...\n-----END PGP SIGNATURE-----\n"
+	_, err := DearmorSignature(gpgBlock)
+	if err == nil {
+		t.Fatal("expected an error for a GPG-armored signature")
+	}
+	if !strings.Contains(err.Error(), "GPG") {
+		t.Errorf("error = %q, want it to call out the GPG mismatch", err)
+	}
+}
+
+// TestDearmorSignatureRejectsMinisignFormat checks the same for a
+// minisign signature file, which starts with its own "untrusted
+// comment:" header rather than an armor block.
+func TestDearmorSignatureRejectsMinisignFormat(t *testing.T) {
+	minisig := "untrusted comment: signature from minisign secret key\nRWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y91YGe\n"
+	_, err := DearmorSignature(minisig)
+	if err == nil {
+		t.Fatal("expected an error for a minisign signature")
+	}
+	if !strings.Contains(err.Error(), "minisign") {
+		t.Errorf("error = %q, want it to call out the minisign mismatch", err)
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hsync/internal/protocol"
+	"hsync/internal/utils"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Revision describes one historical snapshot of a file, recorded in that
+// file's index.json.
+type Revision struct {
+	ID          string    `json:"id"`
+	Timestamp   time.Time `json:"timestamp"`
+	AuthorKeyID string    `json:"authorKeyId"`
+	Hash        string    `json:"hash"`
+}
+
+// revisionDir returns the directory filename's revisions (and its
+// index.json) are stored under.
+func (s *Server) revisionDir(filename string) string {
+	return filepath.Join(s.DataDir, ".hsync", filepath.FromSlash(filename))
+}
+
+func (s *Server) revisionIndexPath(filename string) string {
+	return filepath.Join(s.revisionDir(filename), "index.json")
+}
+
+// loadRevisionIndex reads filename's revision index, returning an empty
+// slice (not an error) if no revisions have been recorded yet.
+func (s *Server) loadRevisionIndex(filename string) ([]Revision, error) {
+	data, err := os.ReadFile(s.revisionIndexPath(filename))
+	if os.IsNotExist(err) {
+		return []Revision{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var revs []Revision
+	if err := json.Unmarshal(data, &revs); err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
+
+func (s *Server) saveRevisionIndex(filename string, revs []Revision) error {
+	data, err := json.Marshal(revs)
+	if err != nil {
+		return err
+	}
+	return atomicWriteFile(s.revisionIndexPath(filename), data)
+}
+
+// snapshotRevision records content as a new revision of filename, so it
+// can later be listed via ?revisions=1, downloaded via ?rev=<id>, or
+// promoted back to current via ?restore=<id>.
+func (s *Server) snapshotRevision(filename, content, authorKeyID string) error {
+	dir := s.revisionDir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	hash := utils.CalculateHash(content)
+	id := fmt.Sprintf("%d-%s", time.Now().UnixNano(), hash[:8])
+	if err := atomicWriteFile(filepath.Join(dir, id+".txt"), []byte(content)); err != nil {
+		return err
+	}
+
+	revs, err := s.loadRevisionIndex(filename)
+	if err != nil {
+		return err
+	}
+	revs = append(revs, Revision{
+		ID:          id,
+		Timestamp:   time.Now(),
+		AuthorKeyID: authorKeyID,
+		Hash:        hash,
+	})
+	return s.saveRevisionIndex(filename, revs)
+}
+
+// shortKeyID derives a short, non-reversible identifier for a sync key,
+// suitable for attributing a revision to its author without persisting
+// the key itself.
+func shortKeyID(k string) string {
+	sum := sha256.Sum256([]byte(k))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// handleRestore promotes revID back to being filename's current content,
+// first snapshotting what it replaces so the restore itself is
+// reversible.
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request, filename, revID string) {
+	unlock := s.fileLocks.Lock(filename)
+	defer unlock()
+
+	revPath := filepath.Join(s.revisionDir(filename), filepath.Base(revID)+".txt")
+	revContent, err := os.ReadFile(revPath)
+	if os.IsNotExist(err) {
+		http.Error(w, "Revision Not Found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Read revision error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	serverPath := filepath.Join(s.DataDir, filepath.FromSlash(filename))
+	if current, err := os.ReadFile(serverPath); err == nil {
+		if err := s.snapshotRevision(filename, string(current), shortKeyID(r.Header.Get("X-Sync-Key"))); err != nil {
+			log.Printf("Revision snapshot error: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("ReadFile error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(serverPath), 0755); err != nil {
+		log.Printf("MkdirAll error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := atomicWriteFile(serverPath, revContent); err != nil {
+		log.Printf("Write error: %v", err)
+		http.Error(w, "Write Error", http.StatusInternalServerError)
+		return
+	}
+
+	s.broadcast(protocol.Event{Filename: filename, Hash: utils.CalculateHash(string(revContent))})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.SyncResponse{Synced: string(revContent)})
+}
+
+// startRevisionPruner kicks off a background goroutine enforcing
+// KeepRevisions and KeepDays, if either was set. With both left at their
+// zero value, revisions accumulate forever.
+func (s *Server) startRevisionPruner() {
+	if s.KeepRevisions <= 0 && s.KeepDays <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.pruneRevisions()
+		}
+	}()
+}
+
+// pruneRevisions walks every file's revision directory under DataDir and
+// applies the retention policy to each.
+func (s *Server) pruneRevisions() {
+	root := filepath.Join(s.DataDir, ".hsync")
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() != "index.json" {
+			return nil
+		}
+		filename := filepath.ToSlash(mustRel(root, filepath.Dir(p)))
+		s.pruneFileRevisions(filename)
+		return nil
+	})
+}
+
+// pruneFileRevisions drops filename's oldest revisions (and their
+// content files) down to KeepRevisions, and any older than KeepDays.
+func (s *Server) pruneFileRevisions(filename string) {
+	unlock := s.fileLocks.Lock(filename)
+	defer unlock()
+
+	revs, err := s.loadRevisionIndex(filename)
+	if err != nil || len(revs) == 0 {
+		return
+	}
+
+	keep := make([]Revision, 0, len(revs))
+	cutoff := time.Now().AddDate(0, 0, -s.KeepDays)
+	for i, rev := range revs {
+		remaining := len(revs) - i
+		tooOld := s.KeepDays > 0 && rev.Timestamp.Before(cutoff)
+		tooMany := s.KeepRevisions > 0 && remaining > s.KeepRevisions
+		if tooOld || tooMany {
+			if err := os.Remove(filepath.Join(s.revisionDir(filename), rev.ID+".txt")); err != nil && !os.IsNotExist(err) {
+				log.Printf("Prune error for %s rev %s: %v", filename, rev.ID, err)
+			}
+			continue
+		}
+		keep = append(keep, rev)
+	}
+
+	if len(keep) != len(revs) {
+		if err := s.saveRevisionIndex(filename, keep); err != nil {
+			log.Printf("Prune index save error for %s: %v", filename, err)
+		}
+	}
+}
@@ -1,48 +1,139 @@
 package server
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
+	"fmt"
+	"hsync/internal/ignore"
 	"hsync/internal/merger"
 	"hsync/internal/protocol"
 	"hsync/internal/utils"
+	"io"
+	"io/fs"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
+// defaultMaxUploadBytes is used by maxUploadBytes when Server's
+// MaxUploadBytes is unset (its zero value). Unlike KeepRevisions/
+// KeepDays, a zero upload limit isn't a sensible "unbounded" default
+// here: a caller who forgot to set it should still get a bounded cap,
+// not an unannounced way to exhaust server memory/disk.
+const defaultMaxUploadBytes = 100 << 20 // 100 MiB
+
 type Server struct {
 	Addr    string
 	Key     string
 	DataDir string
-	mu      sync.Mutex
+	// AllowedExtensions restricts which file extensions may be synced.
+	// Empty means allow anything not rejected by the ignore matcher.
+	AllowedExtensions []string
+	// KeepRevisions and KeepDays bound the revision history retained per
+	// file; zero means unbounded and disables the pruner entirely.
+	KeepRevisions int
+	KeepDays      int
+	// MaxUploadBytes caps the size of a single multipart upload part
+	// (base or latest) handleMultipartUpload will spool to disk; zero
+	// falls back to defaultMaxUploadBytes via maxUploadBytes.
+	MaxUploadBytes int64
+	// TrustedKeys are the ed25519 public keys an upload's optional
+	// Signature is checked against; a signed upload that matches none of
+	// them is rejected, so with none configured every signed upload is
+	// rejected. Loaded once at startup from -pubkeys.
+	TrustedKeys []ed25519.PublicKey
+	mu          sync.Mutex // guards subscribers only; file access uses fileLocks
+
+	subscribers   map[chan protocol.Event]struct{}
+	ignoreMatcher *ignore.Matcher
+	fileLocks     *keyedMutex
+}
+
+// keyedMutex hands out a *sync.Mutex per key, created on first use and
+// kept for the process lifetime, so syncs of different files don't
+// serialize behind each other the way a single server-wide lock would.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*sync.Mutex)}
+}
+
+// Lock acquires the mutex for key and returns a function to release it.
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		k.locks[key] = lock
+	}
+	k.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
 }
 
 func Run(args []string) {
 	fs := flag.NewFlagSet("server", flag.ExitOnError)
 	s := &Server{}
+	var allowedExtensions string
+	var pubKeysPath string
 	fs.StringVar(&s.Addr, "addr", ":8080", "Address to listen on")
 	fs.StringVar(&s.Key, "key", "default-secret", "Shared key for authentication")
 	fs.StringVar(&s.DataDir, "dir", "data", "Path to the server-side data directory")
+	fs.StringVar(&allowedExtensions, "allowed-extensions", "", "Comma-separated list of allowed file extensions (default: allow all not ignored)")
+	fs.IntVar(&s.KeepRevisions, "keep-revisions", 0, "Maximum number of revisions to retain per file (0 keeps all)")
+	fs.IntVar(&s.KeepDays, "keep-days", 0, "Maximum age in days of a revision to retain (0 keeps all)")
+	fs.Int64Var(&s.MaxUploadBytes, "max-upload-bytes", defaultMaxUploadBytes, "Maximum accepted size in bytes for a single multipart upload part (base or latest)")
+	fs.StringVar(&pubKeysPath, "pubkeys", "", "Path to a keyring file of hex-encoded ed25519 public keys trusted to sign uploads (default: none; signed uploads are then rejected)")
 
 	if err := fs.Parse(args); err != nil {
 		log.Fatal(err)
 	}
+	if allowedExtensions != "" {
+		s.AllowedExtensions = strings.Split(allowedExtensions, ",")
+	}
+	if pubKeysPath != "" {
+		keys, err := loadKeyring(pubKeysPath)
+		if err != nil {
+			log.Fatalf("Error loading keyring: %v", err)
+		}
+		s.TrustedKeys = keys
+	}
 
 	// Ensure data directory exists
 	if err := os.MkdirAll(s.DataDir, 0755); err != nil {
 		log.Fatal(err)
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/sync", s.handleSync)
+	matcher, err := ignore.Load(filepath.Join(s.DataDir, ".hsyncignore"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.ignoreMatcher = matcher
+	s.fileLocks = newKeyedMutex()
+
+	s.subscribers = make(map[chan protocol.Event]struct{})
+	s.startRevisionPruner()
 
 	srv := &http.Server{
 		Addr:    s.Addr,
-		Handler: mux,
+		Handler: s.mux(),
 	}
 
 	log.Printf("Server listening on %s", s.Addr)
@@ -52,6 +143,17 @@ func Run(args []string) {
 	}
 }
 
+// mux wires up s's HTTP handlers, factored out of Run so tests can drive
+// the server through net/http/httptest without binding a real listener.
+func (s *Server) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sync", s.handleSync)
+	mux.HandleFunc("/sync/signature", s.handleSignature)
+	mux.HandleFunc("/sync/delta", s.handleDelta)
+	mux.HandleFunc("/sync/events", s.handleEvents)
+	return mux
+}
+
 func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
 	// Apply Auth
 	if r.Header.Get("X-Sync-Key") != s.Key {
@@ -59,112 +161,976 @@ func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	if r.Method == http.MethodGet {
 		filename := r.URL.Query().Get("filename")
 
 		// Case 1: Download specific file content
 		if filename != "" {
-			// Security check
-			cleanName := filepath.Base(filename)
-			if cleanName == "." || cleanName == "/" || !strings.HasSuffix(cleanName, ".txt") {
+			relPath, ok := sanitizeFilename(filename)
+			if !ok || !s.isAllowed(relPath) {
 				http.Error(w, "Invalid Filename", http.StatusBadRequest)
 				return
 			}
-			path := filepath.Join(s.DataDir, cleanName)
-			content, err := os.ReadFile(path)
+
+			unlock := s.fileLocks.Lock(relPath)
+			defer unlock()
+
+			// Case 1a: list this file's revision history instead of its
+			// content.
+			if r.URL.Query().Get("revisions") != "" {
+				revs, err := s.loadRevisionIndex(relPath)
+				if err != nil {
+					log.Printf("Revision index error: %v", err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(revs)
+				return
+			}
+
+			// Case 1c: fetch the detached signature sidecar instead of the
+			// file's own content.
+			if r.URL.Query().Get("sig") != "" {
+				data, err := os.ReadFile(filepath.Join(s.DataDir, filepath.FromSlash(relPath)+".asc"))
+				if os.IsNotExist(err) {
+					http.Error(w, "Not Found", http.StatusNotFound)
+					return
+				} else if err != nil {
+					log.Printf("Read signature error: %v", err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.Write(data)
+				return
+			}
+
+			// Case 1b: stream a historical revision without touching the
+			// current file.
+			servePath := filepath.Join(s.DataDir, filepath.FromSlash(relPath))
+			if revID := r.URL.Query().Get("rev"); revID != "" {
+				servePath = filepath.Join(s.revisionDir(relPath), filepath.Base(revID)+".txt")
+			}
+
+			f, err := os.Open(servePath)
 			if os.IsNotExist(err) {
 				http.Error(w, "Not Found", http.StatusNotFound)
 				return
 			} else if err != nil {
-				log.Printf("ReadFile error: %v", err)
+				log.Printf("Open error: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			defer f.Close()
+
+			info, err := f.Stat()
+			if err != nil {
+				log.Printf("Stat error: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			// Read the whole file up front rather than streaming it
+			// straight from f: setIntegrityHeaders needs the complete
+			// content to hash before any bytes go out, and ServeContent
+			// still gets to do its own Range slicing over the in-memory
+			// copy.
+			data, err := io.ReadAll(f)
+			if err != nil {
+				log.Printf("Read error: %v", err)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 				return
 			}
+
+			// http.ServeContent parses Range (single, open-ended and suffix
+			// forms), answers with 206/416 as appropriate, and sets
+			// Content-Range/Accept-Ranges, so a client that lost its
+			// connection mid-download can resume without refetching from
+			// byte zero.
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			w.Write(content)
+			setIntegrityHeaders(w, r, data)
+			http.ServeContent(w, r, relPath, info.ModTime(), bytes.NewReader(data))
 			return
 		}
 
-		// Case 2: List files with hashes
-		files := make(map[string]string)
-		entries, err := os.ReadDir(s.DataDir)
+		// Case 2: List files with hashes, walked recursively
+		hasher := negotiatedHasher(r)
+		files, err := s.listFiles(hasher)
 		if err != nil {
-			log.Printf("ReadDir error: %v", err)
+			log.Printf("Walk error: %v", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		for _, entry := range entries {
-			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".txt") {
-				content, err := os.ReadFile(filepath.Join(s.DataDir, entry.Name()))
-				if err != nil {
-					log.Printf("ReadFile error (%s): %v", entry.Name(), err)
-					continue
-				}
-				files[entry.Name()] = utils.CalculateHash(string(content))
-			}
+		body, err := json.Marshal(files)
+		if err != nil {
+			log.Printf("Marshal error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(files)
+		setIntegrityHeaders(w, r, body)
+		w.Write(body)
 		return
 	}
 
 	if r.Method == http.MethodPost {
+		// Restore mode: promote a historical revision to current without
+		// going through a 3-way merge.
+		if revID := r.URL.Query().Get("restore"); revID != "" {
+			relPath, ok := sanitizeFilename(r.URL.Query().Get("filename"))
+			if !ok || !s.isAllowed(relPath) {
+				http.Error(w, "Invalid Filename", http.StatusBadRequest)
+				return
+			}
+			s.handleRestore(w, r, relPath, revID)
+			return
+		}
+
+		// A large sync arrives as streamed multipart parts instead of a
+		// single JSON body; see handleMultipartUpload.
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && strings.HasPrefix(mediaType, "multipart/") {
+			s.handleMultipartUpload(w, r)
+			return
+		}
+
+		// The JSON path carries Base and Latest as plain strings, so it's
+		// just as capable of ballooning server memory as an unbounded
+		// multipart part; cap it the same way handleMultipartUpload caps
+		// its combined parts.
+		r.Body = http.MaxBytesReader(w, r.Body, 2*s.maxUploadBytes())
+
 		var req protocol.SyncRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Bad Request", http.StatusBadRequest)
 			return
 		}
 
-		// Security check: simple sanitize
-		filename := filepath.Base(req.Filename)
-		if filename == "." || filename == "/" {
+		filename, ok := sanitizeFilename(req.Filename)
+		if !ok || !s.isAllowed(filename) {
 			http.Error(w, "Invalid Filename", http.StatusBadRequest)
 			return
 		}
-		// Enforce .txt extension for safety/simplicity per requirement context
-		if !strings.HasSuffix(filename, ".txt") {
-			http.Error(w, "Only .txt files allowed", http.StatusBadRequest)
+
+		// Require an end-to-end integrity check covering the uploaded
+		// content, independent of transport-level checks, before touching
+		// disk.
+		algo, digest, ok := utils.ParseContentHash(r.Header.Get("X-Content-Hash"))
+		if !ok {
+			http.Error(w, "Missing X-Content-Hash", http.StatusBadRequest)
+			return
+		}
+		if utils.HasherFor(algo).Sum(req.Latest) != digest {
+			http.Error(w, "Content Hash Mismatch", http.StatusBadRequest)
 			return
 		}
 
-		serverPath := filepath.Join(s.DataDir, filename)
-		serverContentBytes, err := os.ReadFile(serverPath)
-		serverContent := ""
-		if err == nil {
-			serverContent = string(serverContentBytes)
-		} else if !os.IsNotExist(err) {
-			log.Printf("ReadFile error: %v", err)
+		if req.Signature != "" {
+			if err := s.verifySignature([]byte(req.Latest), req.Signature); err != nil {
+				log.Printf("Signature rejected for %s: %v", filename, err)
+				http.Error(w, "Invalid Signature", http.StatusBadRequest)
+				return
+			}
+		}
+
+		unlock := s.fileLocks.Lock(filename)
+		defer unlock()
+
+		if req.Encrypted {
+			s.saveEncrypted(w, filename, req.Base, req.Latest)
+			return
+		}
+
+		merged, conflictFile, status, err := s.mergeAndSave(filename, req.Base, req.Latest, r.Header.Get("X-Sync-Device"), shortKeyID(r.Header.Get("X-Sync-Key")))
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		// The signature covers Latest, so it's only forwarded as the new
+		// sidecar when the merge actually landed Latest verbatim (a
+		// non-conflicting merge of concurrent server-side edits can
+		// produce content that differs from Latest, which the signature
+		// no longer describes).
+		if conflictFile == "" && req.Signature != "" && merged == req.Latest {
+			serverPath := filepath.Join(s.DataDir, filepath.FromSlash(filename))
+			if err := atomicWriteFile(serverPath+".asc", []byte(req.Signature)); err != nil {
+				log.Printf("Write signature error: %v", err)
+			}
+		}
+
+		resp := protocol.SyncResponse{
+			Synced:       merged,
+			ConflictFile: conflictFile,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+}
+
+// sanitizeFilename cleans a client-supplied relative path (slash-
+// separated on the wire) and rejects anything that could escape
+// DataDir: absolute paths and ".." segments.
+func sanitizeFilename(raw string) (string, bool) {
+	if raw == "" || path.IsAbs(raw) {
+		return "", false
+	}
+	clean := path.Clean(raw)
+	if clean == "." || clean == ".." {
+		return "", false
+	}
+	for _, seg := range strings.Split(clean, "/") {
+		if seg == ".." {
+			return "", false
+		}
+	}
+	return clean, true
+}
+
+// isAllowed reports whether relPath passes the server's .hsyncignore
+// rules and, if configured, its AllowedExtensions allowlist.
+func (s *Server) isAllowed(relPath string) bool {
+	if s.ignoreMatcher.Match(relPath, false) {
+		return false
+	}
+	if len(s.AllowedExtensions) == 0 {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(relPath))
+	for _, allowed := range s.AllowedExtensions {
+		if !strings.HasPrefix(allowed, ".") {
+			allowed = "." + allowed
+		}
+		if strings.ToLower(allowed) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// listFiles walks DataDir recursively and returns the hash of every
+// file that passes isAllowed, other than .hsyncignore and ".asc"
+// signature sidecars, keyed by its slash-separated path
+// relative to DataDir, digested with hasher.
+func (s *Server) listFiles(hasher utils.Hasher) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.WalkDir(s.DataDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == s.DataDir {
+			return nil
+		}
+
+		relPath := filepath.ToSlash(mustRel(s.DataDir, p))
+
+		if d.IsDir() {
+			if relPath == ".hsync" || s.ignoreMatcher.Match(relPath, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if relPath == ".hsyncignore" || strings.HasSuffix(relPath, ".asc") || !s.isAllowed(relPath) {
+			return nil
+		}
+
+		content, err := os.ReadFile(p)
+		if err != nil {
+			log.Printf("ReadFile error (%s): %v", relPath, err)
+			return nil
+		}
+		files[relPath] = hasher.Sum(string(content))
+		return nil
+	})
+	return files, err
+}
+
+// negotiatedHasher picks the digest algorithm a client asked for via
+// X-Hash-Algo or the more conventional Accept-Hash header, falling back
+// to utils.DefaultHashAlgo if neither is set or the algorithm named isn't
+// registered.
+func negotiatedHasher(r *http.Request) utils.Hasher {
+	algo := r.Header.Get("X-Hash-Algo")
+	if algo == "" {
+		algo = r.Header.Get("Accept-Hash")
+	}
+	return utils.HasherFor(algo)
+}
+
+// setIntegrityHeaders stamps a response with the negotiated
+// X-Content-Hash (and an X-Hash-Algo echo) plus the standard ETag and
+// Content-MD5 headers, so a client can verify what it received
+// independent of transport-level checks.
+func setIntegrityHeaders(w http.ResponseWriter, r *http.Request, data []byte) {
+	hasher := negotiatedHasher(r)
+	digest := hasher.Sum(string(data))
+	md5Sum := md5.Sum(data)
+
+	w.Header().Set("X-Content-Hash", hasher.Name()+"="+digest)
+	w.Header().Set("X-Hash-Algo", hasher.Name())
+	w.Header().Set("ETag", `"`+digest+`"`)
+	w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Sum[:]))
+}
+
+// mustRel is filepath.Rel without the error return, for use where base
+// is always an ancestor of target by construction.
+func mustRel(base, target string) string {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return target
+	}
+	return rel
+}
+
+// readServerContent returns the current on-disk content for filename,
+// or "" if it doesn't exist yet.
+func (s *Server) readServerContent(filename string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(s.DataDir, filepath.FromSlash(filename)))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(data), err
+}
+
+// mergeAndSave runs the 3-way merge of base/latest against whatever the
+// server currently holds for filename and persists the result. When the
+// merge is ambiguous, the server's own version stays canonical and
+// latest is instead written to a conflict sibling file attributed to
+// deviceID, whose path is returned as the second result. Otherwise, the
+// content it replaces is snapshotted as a new revision attributed to
+// authorKeyID before being overwritten.
+func (s *Server) mergeAndSave(filename, base, latest, deviceID, authorKeyID string) (string, string, int, error) {
+	serverPath := filepath.Join(s.DataDir, filepath.FromSlash(filename))
+	_, statErr := os.Stat(serverPath)
+	existed := statErr == nil
+
+	serverContent, err := s.readServerContent(filename)
+	if err != nil {
+		log.Printf("ReadFile error: %v", err)
+		return "", "", http.StatusInternalServerError, fmt.Errorf("Internal Server Error")
+	}
+
+	merged, conflicted, err := merger.ThreeWayMerge(base, latest, serverContent)
+	if err != nil {
+		log.Printf("Merge error: %v", err)
+		return "", "", http.StatusInternalServerError, fmt.Errorf("Merge Error")
+	}
+
+	if conflicted {
+		conflictRelPath := conflictFilename(filename, deviceID)
+		conflictPath := filepath.Join(s.DataDir, filepath.FromSlash(conflictRelPath))
+		if err := os.MkdirAll(filepath.Dir(conflictPath), 0755); err != nil {
+			log.Printf("MkdirAll error: %v", err)
+			return "", "", http.StatusInternalServerError, fmt.Errorf("Internal Server Error")
+		}
+		if err := atomicWriteFile(conflictPath, []byte(latest)); err != nil {
+			log.Printf("Write error: %v", err)
+			return "", "", http.StatusInternalServerError, fmt.Errorf("Write Error")
+		}
+		log.Printf("Conflicting edit to %s: kept server version, wrote client's as %s", filename, conflictRelPath)
+		return serverContent, conflictRelPath, http.StatusOK, nil
+	}
+
+	// Snapshot what's about to be replaced so it can be recovered later
+	// via ?rev=<id> or ?restore=<id>.
+	if existed {
+		if err := s.snapshotRevision(filename, serverContent, authorKeyID); err != nil {
+			log.Printf("Revision snapshot error: %v", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(serverPath), 0755); err != nil {
+		log.Printf("MkdirAll error: %v", err)
+		return "", "", http.StatusInternalServerError, fmt.Errorf("Internal Server Error")
+	}
+	if err := atomicWriteFile(serverPath, []byte(merged)); err != nil {
+		log.Printf("Write error: %v", err)
+		return "", "", http.StatusInternalServerError, fmt.Errorf("Write Error")
+	}
+
+	s.broadcast(protocol.Event{Filename: filename, Hash: utils.CalculateHash(merged)})
+
+	return merged, "", http.StatusOK, nil
+}
+
+// saveEncrypted handles an Encrypted SyncRequest, whose Base and Latest
+// are ciphertext the server cannot read: there's no semantic merge it
+// can perform, so it either fast-forwards to Latest when Base still
+// matches what it holds, or reports its current content with 409 so the
+// client can decrypt both sides and merge locally.
+func (s *Server) saveEncrypted(w http.ResponseWriter, filename, base, latest string) {
+	serverContent, err := s.readServerContent(filename)
+	if err != nil {
+		log.Printf("ReadFile error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if serverContent != "" && utils.CalculateHash(serverContent) != utils.CalculateHash(base) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(protocol.ConflictResponse{ServerContent: serverContent})
+		return
+	}
+
+	serverPath := filepath.Join(s.DataDir, filepath.FromSlash(filename))
+	if err := os.MkdirAll(filepath.Dir(serverPath), 0755); err != nil {
+		log.Printf("MkdirAll error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := atomicWriteFile(serverPath, []byte(latest)); err != nil {
+		log.Printf("Write error: %v", err)
+		http.Error(w, "Write Error", http.StatusInternalServerError)
+		return
+	}
+
+	s.broadcast(protocol.Event{Filename: filename, Hash: utils.CalculateHash(latest)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.SyncResponse{Synced: latest})
+}
+
+// atomicWriteFile writes data to a temp file in path's directory,
+// fsyncs it, and renames it over path, so a crash mid-write can never
+// leave a truncated file at path: readers see either the old content or
+// the new, never a partial one.
+func atomicWriteFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op once the rename below succeeds
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
+
+// atomicCopyFile is atomicWriteFile's streaming counterpart: it copies r
+// into a temp file in path's directory, fsyncs it, and renames it over
+// path, for callers that already have an io.Reader instead of a whole
+// []byte.
+func atomicCopyFile(path string, r io.Reader) (int64, error) {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op once the rename below succeeds
+
+	written, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return written, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return written, err
+	}
+	if err := tmp.Close(); err != nil {
+		return written, err
+	}
+	return written, os.Rename(tmpName, path)
+}
+
+// maxUploadBytes returns s.MaxUploadBytes, or defaultMaxUploadBytes if
+// it's unset.
+func (s *Server) maxUploadBytes() int64 {
+	if s.MaxUploadBytes > 0 {
+		return s.MaxUploadBytes
+	}
+	return defaultMaxUploadBytes
+}
+
+// uploadMeta is the small JSON "meta" part of a multipart sync upload;
+// the bulk of the request (the base and latest file contents) travels as
+// separate streamed parts instead of JSON strings, so a large file isn't
+// duplicated into a protocol.SyncRequest's Base/Latest strings just to be
+// decoded.
+type uploadMeta struct {
+	Filename    string `json:"filename"`
+	ContentHash string `json:"contentHash"`
+	// Signature, if set, is an armored ed25519 signature over the
+	// "latest" part's content; see protocol.SyncRequest.Signature.
+	Signature string `json:"signature,omitempty"`
+}
+
+// spoolPart copies part into a temp file under dir, rejecting it once
+// more than limit bytes have been read so a single part can't exhaust
+// disk regardless of what the request claimed upfront.
+func spoolPart(dir string, part *multipart.Part, limit int64) (string, error) {
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	n, err := io.Copy(tmp, io.LimitReader(part, limit+1))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if n > limit {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("part %q exceeds max-upload-bytes", part.FormName())
+	}
+	return tmp.Name(), nil
+}
+
+// spoolPartSHA256 is spoolPart plus a SHA-256 digest computed in the same
+// pass, so validating the upload's X-Content-Hash doesn't require a
+// second read of the spooled file. Multipart uploads require sha256
+// specifically (see handleMultipartUpload), so the algorithm isn't
+// negotiable here the way setIntegrityHeaders/negotiatedHasher are for
+// the JSON path.
+func spoolPartSHA256(dir string, part *multipart.Part, limit int64) (path, hexDigest string, err error) {
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", "", err
+	}
+	defer tmp.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(part, limit+1))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+	if n > limit {
+		os.Remove(tmp.Name())
+		return "", "", fmt.Errorf("part %q exceeds max-upload-bytes", part.FormName())
+	}
+	return tmp.Name(), hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// handleMultipartUpload is the streaming counterpart to handleSync's
+// JSON upload path: it accepts a multipart/form-data body -- a "meta"
+// JSON part plus "base" and "latest" file parts -- and spools each part
+// straight to a file under DataDir instead of decoding it into memory,
+// so a large sync doesn't require the whole request body to be resident
+// as a Go string the way protocol.SyncRequest would. It doesn't support
+// Encrypted requests: ciphertext bodies are expected to stay small, so
+// those keep using the JSON path.
+func (s *Server) handleMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	limit := s.maxUploadBytes()
+	// Cap the whole request, not just the individual parts, as a backstop.
+	r.Body = http.MaxBytesReader(w, r.Body, 2*limit+(64<<10))
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		http.Error(w, "Expected multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+
+	var meta uploadMeta
+	var basePath, latestPath, latestHash string
+	defer func() {
+		if basePath != "" {
+			os.Remove(basePath)
+		}
+		if latestPath != "" {
+			os.Remove(latestPath)
+		}
+	}()
+
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Multipart error: %v", err)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		var partErr error
+		switch part.FormName() {
+		case "meta":
+			partErr = json.NewDecoder(io.LimitReader(part, 64<<10)).Decode(&meta)
+		case "base":
+			basePath, partErr = spoolPart(s.DataDir, part, limit)
+		case "latest":
+			latestPath, latestHash, partErr = spoolPartSHA256(s.DataDir, part, limit)
+		}
+		part.Close()
+		if partErr != nil {
+			log.Printf("Upload part error: %v", partErr)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	filename, ok := sanitizeFilename(meta.Filename)
+	if !ok || !s.isAllowed(filename) {
+		http.Error(w, "Invalid Filename", http.StatusBadRequest)
+		return
+	}
+	if latestPath == "" {
+		http.Error(w, "Missing latest part", http.StatusBadRequest)
+		return
+	}
+
+	algo, digest, ok := utils.ParseContentHash(meta.ContentHash)
+	if !ok || algo != "sha256" || digest != latestHash {
+		http.Error(w, "Content Hash Mismatch", http.StatusBadRequest)
+		return
+	}
+
+	if meta.Signature != "" {
+		latestContent, err := os.ReadFile(latestPath)
+		if err != nil {
+			log.Printf("Read error: %v", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+		if err := s.verifySignature(latestContent, meta.Signature); err != nil {
+			log.Printf("Signature rejected for %s: %v", filename, err)
+			http.Error(w, "Invalid Signature", http.StatusBadRequest)
+			return
+		}
+	}
+
+	unlock := s.fileLocks.Lock(filename)
+	defer unlock()
+
+	serverPath := filepath.Join(s.DataDir, filepath.FromSlash(filename))
+	_, statErr := os.Stat(serverPath)
+	existed := statErr == nil
+
+	serverContent, err := s.readServerContent(filename)
+	if err != nil {
+		log.Printf("ReadFile error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 
-		// Perform 3-way merge
-		merged, err := merger.ThreeWayMerge(req.Base, req.Latest, serverContent)
+	var baseReader io.Reader = strings.NewReader("")
+	if basePath != "" {
+		baseFile, err := os.Open(basePath)
 		if err != nil {
-			log.Printf("Merge error: %v", err)
-			http.Error(w, "Merge Error", http.StatusInternalServerError)
+			log.Printf("Open error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+		defer baseFile.Close()
+		baseReader = baseFile
+	}
 
-		// Save merged content
-		if err := os.WriteFile(serverPath, []byte(merged), 0644); err != nil {
+	latestFile, err := os.Open(latestPath)
+	if err != nil {
+		log.Printf("Open error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer latestFile.Close()
+
+	mergedReader, conflicted, err := merger.ThreeWayMergeReaders(baseReader, latestFile, strings.NewReader(serverContent))
+	if err != nil {
+		log.Printf("Merge error: %v", err)
+		http.Error(w, "Merge Error", http.StatusInternalServerError)
+		return
+	}
+	defer mergedReader.Close()
+
+	if conflicted {
+		conflictRelPath := conflictFilename(filename, r.Header.Get("X-Sync-Device"))
+		conflictPath := filepath.Join(s.DataDir, filepath.FromSlash(conflictRelPath))
+		if err := os.MkdirAll(filepath.Dir(conflictPath), 0755); err != nil {
+			log.Printf("MkdirAll error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		latestCopy, err := os.Open(latestPath)
+		if err != nil {
+			log.Printf("Open error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer latestCopy.Close()
+		if _, err := atomicCopyFile(conflictPath, latestCopy); err != nil {
 			log.Printf("Write error: %v", err)
 			http.Error(w, "Write Error", http.StatusInternalServerError)
 			return
 		}
+		log.Printf("Conflicting edit to %s: kept server version, wrote client's as %s", filename, conflictRelPath)
 
-		resp := protocol.SyncResponse{
-			Synced: merged,
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Conflict-File", conflictRelPath)
+		setIntegrityHeaders(w, r, []byte(serverContent))
+		io.WriteString(w, serverContent)
+		return
+	}
+
+	// Snapshot what's about to be replaced so it can be recovered later
+	// via ?rev=<id> or ?restore=<id>.
+	if existed {
+		if err := s.snapshotRevision(filename, serverContent, shortKeyID(r.Header.Get("X-Sync-Key"))); err != nil {
+			log.Printf("Revision snapshot error: %v", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(serverPath), 0755); err != nil {
+		log.Printf("MkdirAll error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	sha256Hasher := sha256.New()
+	md5Hasher := md5.New()
+	if _, err := atomicCopyFile(serverPath, io.TeeReader(mergedReader, io.MultiWriter(sha256Hasher, md5Hasher))); err != nil {
+		log.Printf("Write error: %v", err)
+		http.Error(w, "Write Error", http.StatusInternalServerError)
+		return
+	}
+
+	digestHex := hex.EncodeToString(sha256Hasher.Sum(nil))
+
+	// The signature covers the "latest" part, so it's only forwarded as
+	// the new sidecar when the merge landed it verbatim (a non-
+	// conflicting merge of concurrent server-side edits can produce
+	// content that differs from it, which the signature no longer
+	// describes).
+	if meta.Signature != "" && digestHex == latestHash {
+		if err := atomicWriteFile(serverPath+".asc", []byte(meta.Signature)); err != nil {
+			log.Printf("Write signature error: %v", err)
 		}
+	}
+
+	s.broadcast(protocol.Event{Filename: filename, Hash: digestHex})
+
+	mergedFile, err := os.Open(serverPath)
+	if err != nil {
+		log.Printf("Open error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer mergedFile.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Hash", "sha256="+digestHex)
+	w.Header().Set("X-Hash-Algo", "sha256")
+	w.Header().Set("ETag", `"`+digestHex+`"`)
+	w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Hasher.Sum(nil)))
+	io.Copy(w, mergedFile)
+}
+
+// conflictFilename builds a Syncthing-style conflict sibling path for
+// filename, attributed to deviceID: "<stem>.sync-conflict-<UTC
+// timestamp>-<deviceID><ext>", relative to the sync root. deviceID comes
+// straight from the client-supplied X-Sync-Device header, so it's run
+// through sanitizeDeviceID first: without that, a device ID containing
+// "/" or ".." segments would ride along into the filepath.Join'd path and
+// let a client write its conflict copy outside DataDir entirely.
+func conflictFilename(filename, deviceID string) string {
+	ext := path.Ext(filename)
+	stem := strings.TrimSuffix(filename, ext)
+	timestamp := time.Now().UTC().Format("20060102-150405")
+	return fmt.Sprintf("%s.sync-conflict-%s-%s%s", stem, timestamp, sanitizeDeviceID(deviceID), ext)
+}
+
+// sanitizeDeviceID returns deviceID if it's safe to splice into a path
+// segment, or "unknown" otherwise. Unlike sanitizeFilename it never
+// fails the request: a device ID is attribution, not addressing, so an
+// untrusted or empty value just degrades to "unknown" instead of
+// rejecting the upload.
+func sanitizeDeviceID(deviceID string) string {
+	if deviceID == "" || strings.ContainsAny(deviceID, "/\\") || strings.Contains(deviceID, "..") {
+		return "unknown"
+	}
+	return deviceID
+}
+
+// broadcast notifies every subscriber of handleEvents about a changed
+// file. It never blocks on a slow subscriber: a subscriber that can't
+// keep up with its small buffer just misses this event and catches up
+// on the next periodic reconciliation. It holds s.mu while iterating,
+// since handleEvents adds/removes subscribers under the same lock from
+// other goroutines.
+func (s *Server) broadcast(event protocol.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// handleEvents streams file-change events to a client as Server-Sent
+// Events for as long as the connection stays open.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Sync-Key") != s.Key {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan protocol.Event, 16)
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, ch)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case event := <-ch:
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Error marshaling event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleSignature serves the block signature of the server's current
+// copy of a file (GET), so a peer can compute an upload delta against
+// it, or accepts a peer's signature (POST) and returns a Delta that
+// reconstructs the server's current content when replayed against the
+// blocks that signature was built from, for delta downloads.
+func (s *Server) handleSignature(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Sync-Key") != s.Key {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		filename, ok := sanitizeFilename(r.URL.Query().Get("filename"))
+		if !ok || !s.isAllowed(filename) {
+			http.Error(w, "Invalid Filename", http.StatusBadRequest)
+			return
+		}
+		unlock := s.fileLocks.Lock(filename)
+		defer unlock()
+
+		content, err := s.readServerContent(filename)
+		if err != nil {
+			log.Printf("ReadFile error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		sig := protocol.BuildSignature(filename, []byte(content))
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(resp)
+		json.NewEncoder(w).Encode(sig)
+
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxUploadBytes())
+		var sig protocol.Signature
+		if err := json.NewDecoder(r.Body).Decode(&sig); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		filename, ok := sanitizeFilename(sig.Filename)
+		if !ok || !s.isAllowed(filename) {
+			http.Error(w, "Invalid Filename", http.StatusBadRequest)
+			return
+		}
+		unlock := s.fileLocks.Lock(filename)
+		defer unlock()
+
+		content, err := s.readServerContent(filename)
+		if err != nil {
+			log.Printf("ReadFile error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		delta := protocol.BuildDelta(filename, []byte(content), sig)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(delta)
+
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDelta accepts an upload expressed as a Delta against the
+// server's own signature, reconstructs the client's Latest content by
+// replaying it against the server's current file, and runs the usual
+// 3-way merge.
+func (s *Server) handleDelta(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Sync-Key") != s.Key {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	r.Body = http.MaxBytesReader(w, r.Body, 2*s.maxUploadBytes())
+
+	var req protocol.DeltaUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	filename, ok := sanitizeFilename(req.Filename)
+	if !ok || !s.isAllowed(filename) {
+		http.Error(w, "Invalid Filename", http.StatusBadRequest)
+		return
+	}
+
+	unlock := s.fileLocks.Lock(filename)
+	defer unlock()
+
+	serverContent, err := s.readServerContent(filename)
+	if err != nil {
+		log.Printf("ReadFile error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	latest := string(protocol.ApplyDelta(req.Delta, []byte(serverContent), protocol.BlockSize))
+
+	merged, conflictFile, status, err := s.mergeAndSave(filename, req.Base, latest, r.Header.Get("X-Sync-Device"), shortKeyID(r.Header.Get("X-Sync-Key")))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	resp := protocol.SyncResponse{Synced: merged, ConflictFile: conflictFile}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
@@ -0,0 +1,1095 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"hsync/internal/ignore"
+	"hsync/internal/protocol"
+	"hsync/internal/utils"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestServer builds a Server rooted at a fresh temp directory and
+// returns it alongside an httptest.Server driving its mux.
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	s := &Server{
+		Addr:          ":0",
+		Key:           "test-key",
+		DataDir:       t.TempDir(),
+		subscribers:   make(map[chan protocol.Event]struct{}),
+		ignoreMatcher: ignore.New(nil),
+		fileLocks:     newKeyedMutex(),
+	}
+	return s, httptest.NewServer(s.mux())
+}
+
+func TestHandleSyncRangeRequest(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	content := strings.Repeat("0123456789", 100) // 1000 bytes
+	if err := os.WriteFile(filepath.Join(s.DataDir, "note.txt"), []byte(content), 0644); err != nil {
+		t.Fatalf("seed file: %v", err)
+	}
+
+	req, err := http.NewRequest("GET", ts.URL+"/sync?filename=note.txt", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("X-Sync-Key", s.Key)
+	req.Header.Set("Range", "bytes=500-599")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusPartialContent)
+	}
+	if got, want := resp.Header.Get("Content-Range"), "bytes 500-599/1000"; got != want {
+		t.Errorf("Content-Range = %q, want %q", got, want)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if got, want := string(body), content[500:600]; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
+
+func TestHandleSyncRangeRequestUnsatisfiable(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	content := "short file"
+	if err := os.WriteFile(filepath.Join(s.DataDir, "note.txt"), []byte(content), 0644); err != nil {
+		t.Fatalf("seed file: %v", err)
+	}
+
+	req, err := http.NewRequest("GET", ts.URL+"/sync?filename=note.txt", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("X-Sync-Key", s.Key)
+	req.Header.Set("Range", "bytes=9999-")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusRequestedRangeNotSatisfiable)
+	}
+}
+
+func TestHandleSyncFullRequestAdvertisesAcceptRanges(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	if err := os.WriteFile(filepath.Join(s.DataDir, "note.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("seed file: %v", err)
+	}
+
+	req, err := http.NewRequest("GET", ts.URL+"/sync?filename=note.txt", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("X-Sync-Key", s.Key)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if got := resp.Header.Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("Accept-Ranges = %q, want %q", got, "bytes")
+	}
+}
+
+func postSync(t *testing.T, ts *httptest.Server, key string, req protocol.SyncRequest) (*http.Response, protocol.SyncResponse) {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	httpReq, err := http.NewRequest("POST", ts.URL+"/sync", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	httpReq.Header.Set("X-Sync-Key", key)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Content-Hash", "sha256="+utils.HasherFor("sha256").Sum(req.Latest))
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var syncResp protocol.SyncResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&syncResp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+	return resp, syncResp
+}
+
+// postMultipart uploads filename as streamed multipart parts, the path
+// handleMultipartUpload takes for large syncs, and returns the raw
+// response alongside its fully-read body.
+func postMultipart(t *testing.T, ts *httptest.Server, key, filename, base, latest string) (*http.Response, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	metaPart, err := mw.CreateFormField("meta")
+	if err != nil {
+		t.Fatalf("create meta part: %v", err)
+	}
+	if err := json.NewEncoder(metaPart).Encode(map[string]string{
+		"filename":    filename,
+		"contentHash": "sha256=" + utils.HasherFor("sha256").Sum(latest),
+	}); err != nil {
+		t.Fatalf("encode meta part: %v", err)
+	}
+	basePart, err := mw.CreateFormFile("base", filename)
+	if err != nil {
+		t.Fatalf("create base part: %v", err)
+	}
+	if _, err := basePart.Write([]byte(base)); err != nil {
+		t.Fatalf("write base part: %v", err)
+	}
+	latestPart, err := mw.CreateFormFile("latest", filename)
+	if err != nil {
+		t.Fatalf("create latest part: %v", err)
+	}
+	if _, err := latestPart.Write([]byte(latest)); err != nil {
+		t.Fatalf("write latest part: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("close multipart writer: %v", err)
+	}
+
+	httpReq, err := http.NewRequest("POST", ts.URL+"/sync", &body)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	httpReq.Header.Set("X-Sync-Key", key)
+	httpReq.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read response body: %v", err)
+	}
+	return resp, string(respBody)
+}
+
+// TestHandleSyncMultipartUpload drives the streamed-parts path a large
+// sync takes instead of a JSON protocol.SyncRequest, and checks it
+// produces the same end state: the merged content on disk, and its hash
+// echoed back via X-Content-Hash.
+func TestHandleSyncMultipartUpload(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	content := "first version"
+	resp, body := postMultipart(t, ts, "test-key", "note.txt", "", content)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if body != content {
+		t.Errorf("response body = %q, want %q", body, content)
+	}
+	wantHash := "sha256=" + utils.HasherFor("sha256").Sum(content)
+	if got := resp.Header.Get("X-Content-Hash"); got != wantHash {
+		t.Errorf("X-Content-Hash = %q, want %q", got, wantHash)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.DataDir, "note.txt"))
+	if err != nil {
+		t.Fatalf("read note.txt: %v", err)
+	}
+	if string(data) != content {
+		t.Errorf("on-disk content = %q, want %q", data, content)
+	}
+}
+
+// TestHandleSyncJSONUploadEnforcesMaxUploadBytes checks that the plain
+// JSON upload path is capped by MaxUploadBytes too, not just the
+// multipart path: a client shouldn't be able to balloon server memory
+// just by skipping multipart and sending a huge Latest string.
+func TestHandleSyncJSONUploadEnforcesMaxUploadBytes(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+	s.MaxUploadBytes = 16
+
+	latest := strings.Repeat("x", 1024)
+	body, err := json.Marshal(protocol.SyncRequest{
+		Filename: "note.txt",
+		Latest:   latest,
+	})
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	httpReq, err := http.NewRequest("POST", ts.URL+"/sync", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	httpReq.Header.Set("X-Sync-Key", "test-key")
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Content-Hash", "sha256="+utils.HasherFor("sha256").Sum(latest))
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+// TestHandleSyncMultipartUploadConflict checks that a multipart upload
+// whose base has fallen behind the server's current content is rejected
+// the same way the JSON path rejects it: the server's version is kept,
+// and the client's content is written to a conflict sibling instead.
+func TestHandleSyncMultipartUploadConflict(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	if err := os.WriteFile(filepath.Join(s.DataDir, "note.txt"), []byte("line one\nline two\n"), 0644); err != nil {
+		t.Fatalf("seed file: %v", err)
+	}
+
+	resp, body := postMultipart(t, ts, "test-key", "note.txt", "stale base with no relation to the file", "client's conflicting edit")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if body != "line one\nline two\n" {
+		t.Errorf("response body = %q, want server's content kept as-is", body)
+	}
+	conflictFile := resp.Header.Get("X-Conflict-File")
+	if conflictFile == "" {
+		t.Fatal("X-Conflict-File header missing")
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.DataDir, filepath.FromSlash(conflictFile)))
+	if err != nil {
+		t.Fatalf("read conflict file %s: %v", conflictFile, err)
+	}
+	if string(data) != "client's conflicting edit" {
+		t.Errorf("conflict file content = %q, want %q", data, "client's conflicting edit")
+	}
+}
+
+// TestHandleSyncConflictSanitizesDeviceHeader checks that a client can't
+// use a path-traversal X-Sync-Device header to make conflictFilename's
+// output (which is filepath.Join'd onto DataDir) escape DataDir.
+func TestHandleSyncConflictSanitizesDeviceHeader(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	if err := os.WriteFile(filepath.Join(s.DataDir, "note.txt"), []byte("line one\nline two\n"), 0644); err != nil {
+		t.Fatalf("seed file: %v", err)
+	}
+
+	latest := "client's conflicting edit"
+	body, err := json.Marshal(protocol.SyncRequest{
+		Filename: "note.txt",
+		Base:     "stale base with no relation to the file",
+		Latest:   latest,
+	})
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	httpReq, err := http.NewRequest("POST", ts.URL+"/sync", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	httpReq.Header.Set("X-Sync-Key", "test-key")
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Content-Hash", "sha256="+utils.HasherFor("sha256").Sum(latest))
+	httpReq.Header.Set("X-Sync-Device", "../../../../tmp/evil")
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var syncResp protocol.SyncResponse
+	if err := json.NewDecoder(resp.Body).Decode(&syncResp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if syncResp.ConflictFile == "" {
+		t.Fatal("ConflictFile missing")
+	}
+	if strings.Contains(syncResp.ConflictFile, "..") || strings.Contains(syncResp.ConflictFile, "/") {
+		t.Errorf("ConflictFile = %q, want a single sanitized path segment", syncResp.ConflictFile)
+	}
+
+	conflictPath := filepath.Join(s.DataDir, filepath.FromSlash(syncResp.ConflictFile))
+	if !strings.HasPrefix(filepath.Clean(conflictPath), filepath.Clean(s.DataDir)+string(filepath.Separator)) {
+		t.Fatalf("conflict file %s escaped DataDir %s", conflictPath, s.DataDir)
+	}
+	if _, err := os.Stat(conflictPath); err != nil {
+		t.Errorf("conflict file not written under DataDir: %v", err)
+	}
+}
+
+// TestHandleSyncSignedUpload checks that a sync carrying a Signature the
+// server trusts is accepted and stored as a "<file>.asc" sidecar,
+// fetchable afterwards via ?sig=1.
+func TestHandleSyncSignedUpload(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	s.TrustedKeys = []ed25519.PublicKey{pub}
+
+	content := "signed note content"
+	signature := protocol.ArmorSignature(ed25519.Sign(priv, []byte(content)))
+
+	resp, syncResp := postSync(t, ts, "test-key", protocol.SyncRequest{
+		Filename:  "note.txt",
+		Latest:    content,
+		Signature: signature,
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if syncResp.Synced != content {
+		t.Errorf("Synced = %q, want %q", syncResp.Synced, content)
+	}
+
+	sigResp, err := http.DefaultClient.Do(newSignedGetRequest(t, ts, "note.txt"))
+	if err != nil {
+		t.Fatalf("fetch signature: %v", err)
+	}
+	defer sigResp.Body.Close()
+	if sigResp.StatusCode != http.StatusOK {
+		t.Fatalf("signature status = %d, want %d", sigResp.StatusCode, http.StatusOK)
+	}
+	got, err := io.ReadAll(sigResp.Body)
+	if err != nil {
+		t.Fatalf("read signature: %v", err)
+	}
+	if string(got) != signature {
+		t.Errorf("stored signature = %q, want %q", got, signature)
+	}
+}
+
+// TestHandleSyncUploadRejectsUntrustedSignature checks that a Signature
+// which doesn't verify against any of the server's TrustedKeys is
+// rejected outright, rather than silently landing unsigned.
+func TestHandleSyncUploadRejectsUntrustedSignature(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	s.TrustedKeys = []ed25519.PublicKey{pub}
+
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	content := "signed note content"
+	signature := protocol.ArmorSignature(ed25519.Sign(otherPriv, []byte(content)))
+
+	resp, _ := postSync(t, ts, "test-key", protocol.SyncRequest{
+		Filename:  "note.txt",
+		Latest:    content,
+		Signature: signature,
+	})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	if _, err := os.Stat(filepath.Join(s.DataDir, "note.txt")); !os.IsNotExist(err) {
+		t.Errorf("note.txt should not have been written, stat err = %v", err)
+	}
+}
+
+// TestHandleSyncSignatureNotFound checks that fetching the signature
+// sidecar for a file that was never signed returns 404, since signing is
+// opt-in.
+func TestHandleSyncSignatureNotFound(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	if err := os.WriteFile(filepath.Join(s.DataDir, "note.txt"), []byte("unsigned"), 0644); err != nil {
+		t.Fatalf("seed file: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(newSignedGetRequest(t, ts, "note.txt"))
+	if err != nil {
+		t.Fatalf("fetch signature: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func newSignedGetRequest(t *testing.T, ts *httptest.Server, filename string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest("GET", ts.URL+"/sync?filename="+filename+"&sig=1", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("X-Sync-Key", "test-key")
+	return req
+}
+
+// TestHandleSyncParallelPostsDistinctFilenames hammers the server with
+// concurrent uploads to different files, which the per-file lock should
+// let run without serializing behind each other or corrupting one
+// another's content.
+func TestHandleSyncParallelPostsDistinctFilenames(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			filename := fmt.Sprintf("note-%d.txt", i)
+			content := fmt.Sprintf("content for file %d", i)
+			resp, syncResp := postSync(t, ts, "test-key", protocol.SyncRequest{
+				Filename: filename,
+				Base:     "",
+				Latest:   content,
+			})
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("file %d: status = %d, want %d", i, resp.StatusCode, http.StatusOK)
+				return
+			}
+			if syncResp.Synced != content {
+				t.Errorf("file %d: synced = %q, want %q", i, syncResp.Synced, content)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		filename := fmt.Sprintf("note-%d.txt", i)
+		want := fmt.Sprintf("content for file %d", i)
+		data, err := os.ReadFile(filepath.Join(s.DataDir, filename))
+		if err != nil {
+			t.Errorf("file %d: read error: %v", i, err)
+			continue
+		}
+		if string(data) != want {
+			t.Errorf("file %d: on-disk content = %q, want %q", i, data, want)
+		}
+	}
+}
+
+// TestHandleSyncParallelPostsSameFilename hammers the server with
+// concurrent uploads to the same file to check that the per-file lock
+// serializes them so no write is ever torn: each upload's empty Base
+// makes its merge prepend its payload, so the final file must be whole
+// payloads laid end to end, never a half-written mix of two.
+func TestHandleSyncParallelPostsSameFilename(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	const n = 20
+	const payloadSize = 8192
+
+	payloads := make([]string, n)
+	for i := range payloads {
+		payloads[i] = strings.Repeat(string(rune('A'+i)), payloadSize)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, _ := postSync(t, ts, "test-key", protocol.SyncRequest{
+				Filename: "shared.txt",
+				Base:     "",
+				Latest:   payloads[i],
+			})
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("payload %d: status = %d, want %d", i, resp.StatusCode, http.StatusOK)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(filepath.Join(s.DataDir, "shared.txt"))
+	if err != nil {
+		t.Fatalf("read shared.txt: %v", err)
+	}
+	if len(data)%payloadSize != 0 {
+		t.Fatalf("on-disk content length %d is not a multiple of payload size %d; a write was torn", len(data), payloadSize)
+	}
+	for i := 0; i < len(data); i += payloadSize {
+		chunk := data[i : i+payloadSize]
+		want := chunk[0]
+		for _, b := range chunk {
+			if b != want {
+				t.Fatalf("chunk at offset %d is not homogeneous (byte %q amid %q); a write was torn", i, b, want)
+			}
+		}
+	}
+}
+
+// TestHandleEventsConcurrentBroadcastAndSubscribe drives concurrent
+// /sync/events subscribers alongside concurrent uploads that trigger
+// broadcast, so `go test -race` catches a broadcast that iterates
+// s.subscribers without the lock handleEvents uses to add/remove from it.
+func TestHandleEventsConcurrentBroadcastAndSubscribe(t *testing.T) {
+	_, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	const n = 20
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+"/sync/events", nil)
+			if err != nil {
+				t.Errorf("new request: %v", err)
+				return
+			}
+			req.Header.Set("X-Sync-Key", "test-key")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				// The context deadline can cancel the request before a
+				// response is ever read; that's fine for this test.
+				return
+			}
+			defer resp.Body.Close()
+			io.Copy(io.Discard, resp.Body)
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			postSync(t, ts, "test-key", protocol.SyncRequest{
+				Filename: fmt.Sprintf("note-%d.txt", i),
+				Latest:   fmt.Sprintf("content %d", i),
+			})
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// TestHandleSignatureGetServesServerContent checks that GET
+// /sync/signature returns a Signature whose blocks reconstruct the
+// server's current copy of a file via BuildDelta/ApplyDelta against that
+// same content.
+func TestHandleSignatureGetServesServerContent(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	content := strings.Repeat("delta protocol content ", 200)
+	if err := os.WriteFile(filepath.Join(s.DataDir, "note.txt"), []byte(content), 0644); err != nil {
+		t.Fatalf("seed file: %v", err)
+	}
+
+	req, err := http.NewRequest("GET", ts.URL+"/sync/signature?filename=note.txt", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("X-Sync-Key", s.Key)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var sig protocol.Signature
+	if err := json.NewDecoder(resp.Body).Decode(&sig); err != nil {
+		t.Fatalf("decode signature: %v", err)
+	}
+	if len(sig.Blocks) == 0 {
+		t.Fatal("expected at least one block signature")
+	}
+
+	delta := protocol.BuildDelta("note.txt", []byte(content), sig)
+	got := protocol.ApplyDelta(delta, []byte(content), sig.BlockSize)
+	if string(got) != content {
+		t.Errorf("replaying the served signature's delta didn't reconstruct the content")
+	}
+}
+
+// TestHandleSignaturePostReturnsDeltaAgainstServerContent checks that
+// POST /sync/signature, given a caller's Signature of an older version
+// of a file, returns a Delta that reconstructs the server's current
+// content when replayed against that older version.
+func TestHandleSignaturePostReturnsDeltaAgainstServerContent(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	older := strings.Repeat("A", protocol.BlockSize*2)
+	current := older + strings.Repeat("B", protocol.BlockSize)
+	if err := os.WriteFile(filepath.Join(s.DataDir, "note.txt"), []byte(current), 0644); err != nil {
+		t.Fatalf("seed file: %v", err)
+	}
+
+	sig := protocol.BuildSignature("note.txt", []byte(older))
+	body, err := json.Marshal(sig)
+	if err != nil {
+		t.Fatalf("marshal signature: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", ts.URL+"/sync/signature", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("X-Sync-Key", s.Key)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var delta protocol.Delta
+	if err := json.NewDecoder(resp.Body).Decode(&delta); err != nil {
+		t.Fatalf("decode delta: %v", err)
+	}
+
+	got := protocol.ApplyDelta(delta, []byte(older), protocol.BlockSize)
+	if string(got) != current {
+		t.Errorf("delta reconstructed %q, want the server's current content %q", got, current)
+	}
+}
+
+// TestHandleDeltaAppliesUploadAgainstServerContent checks that POST
+// /sync/delta reconstructs the client's Latest by replaying its Delta
+// against the server's current content, then runs the usual merge/save
+// path just as a full upload would.
+func TestHandleDeltaAppliesUploadAgainstServerContent(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	base := strings.Repeat("A", protocol.BlockSize*2)
+	if err := os.WriteFile(filepath.Join(s.DataDir, "note.txt"), []byte(base), 0644); err != nil {
+		t.Fatalf("seed file: %v", err)
+	}
+
+	latest := base + strings.Repeat("B", protocol.BlockSize)
+	sig := protocol.BuildSignature("note.txt", []byte(base))
+	delta := protocol.BuildDelta("note.txt", []byte(latest), sig)
+
+	reqBody, err := json.Marshal(protocol.DeltaUploadRequest{
+		Filename: "note.txt",
+		Base:     base,
+		Delta:    delta,
+	})
+	if err != nil {
+		t.Fatalf("marshal delta upload request: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", ts.URL+"/sync/delta", bytes.NewReader(reqBody))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("X-Sync-Key", s.Key)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var syncResp protocol.SyncResponse
+	if err := json.NewDecoder(resp.Body).Decode(&syncResp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if syncResp.Synced != latest {
+		t.Errorf("Synced = %q, want %q", syncResp.Synced, latest)
+	}
+
+	onDisk, err := os.ReadFile(filepath.Join(s.DataDir, "note.txt"))
+	if err != nil {
+		t.Fatalf("read synced file: %v", err)
+	}
+	if string(onDisk) != latest {
+		t.Errorf("file on disk = %q, want %q", onDisk, latest)
+	}
+}
+
+// TestSyncSnapshotsRevisionOnEachEdit checks that every non-conflicting
+// edit to an existing file snapshots what it replaces, listable via
+// ?revisions=1 and downloadable via ?rev=<id>, but that the first upload
+// of a brand new file doesn't snapshot anything (there's nothing to
+// recover yet).
+func TestSyncSnapshotsRevisionOnEachEdit(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	postSync(t, ts, "test-key", protocol.SyncRequest{Filename: "note.txt", Latest: "version one"})
+
+	req, err := http.NewRequest("GET", ts.URL+"/sync?filename=note.txt&revisions=1", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("X-Sync-Key", s.Key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	var revs []Revision
+	if err := json.NewDecoder(resp.Body).Decode(&revs); err != nil {
+		t.Fatalf("decode revisions: %v", err)
+	}
+	resp.Body.Close()
+	if len(revs) != 0 {
+		t.Fatalf("expected no revisions after the first upload, got %d", len(revs))
+	}
+
+	postSync(t, ts, "test-key", protocol.SyncRequest{Filename: "note.txt", Base: "version one", Latest: "version two"})
+	postSync(t, ts, "test-key", protocol.SyncRequest{Filename: "note.txt", Base: "version two", Latest: "version three"})
+
+	req, err = http.NewRequest("GET", ts.URL+"/sync?filename=note.txt&revisions=1", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("X-Sync-Key", s.Key)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&revs); err != nil {
+		t.Fatalf("decode revisions: %v", err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("expected 2 revisions after 2 edits, got %d", len(revs))
+	}
+
+	req, err = http.NewRequest("GET", fmt.Sprintf("%s/sync?filename=note.txt&rev=%s", ts.URL, revs[0].ID), nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("X-Sync-Key", s.Key)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if string(body) != "version one" {
+		t.Errorf("oldest revision content = %q, want %q", body, "version one")
+	}
+}
+
+// TestHandleRestorePromotesRevisionAndSnapshotsCurrent checks that
+// ?restore=<id> overwrites the current file with that revision's content
+// and itself snapshots what it replaced, so the restore is reversible.
+func TestHandleRestorePromotesRevisionAndSnapshotsCurrent(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	postSync(t, ts, "test-key", protocol.SyncRequest{Filename: "note.txt", Latest: "version one"})
+	postSync(t, ts, "test-key", protocol.SyncRequest{Filename: "note.txt", Base: "version one", Latest: "version two"})
+
+	revs, err := s.loadRevisionIndex("note.txt")
+	if err != nil {
+		t.Fatalf("loadRevisionIndex: %v", err)
+	}
+	if len(revs) != 1 {
+		t.Fatalf("expected 1 revision before restore, got %d", len(revs))
+	}
+	oldestID := revs[0].ID
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/sync?filename=note.txt&restore=%s", ts.URL, oldestID), nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("X-Sync-Key", s.Key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var syncResp protocol.SyncResponse
+	if err := json.NewDecoder(resp.Body).Decode(&syncResp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if syncResp.Synced != "version one" {
+		t.Errorf("Synced = %q, want %q", syncResp.Synced, "version one")
+	}
+
+	onDisk, err := os.ReadFile(filepath.Join(s.DataDir, "note.txt"))
+	if err != nil {
+		t.Fatalf("read restored file: %v", err)
+	}
+	if string(onDisk) != "version one" {
+		t.Errorf("file on disk = %q, want %q", onDisk, "version one")
+	}
+
+	revs, err = s.loadRevisionIndex("note.txt")
+	if err != nil {
+		t.Fatalf("loadRevisionIndex after restore: %v", err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("expected the restore to snapshot what it replaced, got %d revisions", len(revs))
+	}
+}
+
+// TestHandleRestoreUnknownRevisionReturnsNotFound checks that restoring
+// a revision ID that doesn't exist yields 404 rather than silently
+// clobbering the current file.
+func TestHandleRestoreUnknownRevisionReturnsNotFound(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	postSync(t, ts, "test-key", protocol.SyncRequest{Filename: "note.txt", Latest: "original"})
+
+	req, err := http.NewRequest("POST", ts.URL+"/sync?filename=note.txt&restore=does-not-exist", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("X-Sync-Key", s.Key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	onDisk, err := os.ReadFile(filepath.Join(s.DataDir, "note.txt"))
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if string(onDisk) != "original" {
+		t.Errorf("file on disk = %q, want the original content untouched", onDisk)
+	}
+}
+
+// TestPruneFileRevisionsEnforcesKeepRevisions checks that
+// pruneFileRevisions drops the oldest revisions down to KeepRevisions,
+// removing their content files along with their index entries.
+func TestPruneFileRevisionsEnforcesKeepRevisions(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+	s.KeepRevisions = 2
+
+	postSync(t, ts, "test-key", protocol.SyncRequest{Filename: "note.txt", Latest: "v1"})
+	postSync(t, ts, "test-key", protocol.SyncRequest{Filename: "note.txt", Base: "v1", Latest: "v2"})
+	postSync(t, ts, "test-key", protocol.SyncRequest{Filename: "note.txt", Base: "v2", Latest: "v3"})
+	postSync(t, ts, "test-key", protocol.SyncRequest{Filename: "note.txt", Base: "v3", Latest: "v4"})
+
+	revs, err := s.loadRevisionIndex("note.txt")
+	if err != nil {
+		t.Fatalf("loadRevisionIndex: %v", err)
+	}
+	if len(revs) != 3 {
+		t.Fatalf("expected 3 revisions before pruning, got %d", len(revs))
+	}
+
+	s.pruneFileRevisions("note.txt")
+
+	revs, err = s.loadRevisionIndex("note.txt")
+	if err != nil {
+		t.Fatalf("loadRevisionIndex after prune: %v", err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("expected 2 revisions kept, got %d", len(revs))
+	}
+	if revs[0].Hash != utils.CalculateHash("v2") || revs[1].Hash != utils.CalculateHash("v3") {
+		t.Errorf("pruning kept the wrong revisions: %+v", revs)
+	}
+
+	for _, rev := range revs {
+		if _, err := os.Stat(filepath.Join(s.revisionDir("note.txt"), rev.ID+".txt")); err != nil {
+			t.Errorf("kept revision %s should still have its content file: %v", rev.ID, err)
+		}
+	}
+}
+
+// TestPruneFileRevisionsEnforcesKeepDays checks that pruneFileRevisions
+// drops revisions older than KeepDays regardless of count.
+func TestPruneFileRevisionsEnforcesKeepDays(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+	s.KeepDays = 7
+
+	postSync(t, ts, "test-key", protocol.SyncRequest{Filename: "note.txt", Latest: "v1"})
+	postSync(t, ts, "test-key", protocol.SyncRequest{Filename: "note.txt", Base: "v1", Latest: "v2"})
+
+	revs, err := s.loadRevisionIndex("note.txt")
+	if err != nil {
+		t.Fatalf("loadRevisionIndex: %v", err)
+	}
+	if len(revs) != 1 {
+		t.Fatalf("expected 1 revision before backdating, got %d", len(revs))
+	}
+	prunedID := revs[0].ID
+	revs[0].Timestamp = time.Now().AddDate(0, 0, -30)
+	if err := s.saveRevisionIndex("note.txt", revs); err != nil {
+		t.Fatalf("saveRevisionIndex: %v", err)
+	}
+
+	s.pruneFileRevisions("note.txt")
+
+	revs, err = s.loadRevisionIndex("note.txt")
+	if err != nil {
+		t.Fatalf("loadRevisionIndex after prune: %v", err)
+	}
+	if len(revs) != 0 {
+		t.Fatalf("expected the backdated revision to be pruned, got %d left", len(revs))
+	}
+	if _, err := os.Stat(filepath.Join(s.revisionDir("note.txt"), prunedID+".txt")); err == nil {
+		t.Errorf("pruned revision's content file should be removed")
+	}
+}
+
+// TestHandleSyncJSONUploadRejectsTamperedContentHash checks that a JSON
+// upload whose X-Content-Hash doesn't match its Latest body is rejected
+// with 400 before anything is written to disk, independent of whatever
+// transport-level integrity checks already ran.
+func TestHandleSyncJSONUploadRejectsTamperedContentHash(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	body, err := json.Marshal(protocol.SyncRequest{Filename: "note.txt", Latest: "actual content"})
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	httpReq, err := http.NewRequest("POST", ts.URL+"/sync", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	httpReq.Header.Set("X-Sync-Key", s.Key)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Content-Hash", "sha256="+utils.HasherFor("sha256").Sum("a different payload entirely"))
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	if _, err := os.Stat(filepath.Join(s.DataDir, "note.txt")); !os.IsNotExist(err) {
+		t.Errorf("a tampered upload should not have touched disk, stat err = %v", err)
+	}
+}
+
+// TestHandleSyncMultipartUploadRejectsTamperedContentHash is the
+// multipart-upload equivalent of
+// TestHandleSyncJSONUploadRejectsTamperedContentHash: meta.contentHash
+// must match the sha256 of the streamed "latest" part.
+func TestHandleSyncMultipartUploadRejectsTamperedContentHash(t *testing.T) {
+	s, ts := newTestServer(t)
+	t.Cleanup(ts.Close)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	metaPart, err := mw.CreateFormField("meta")
+	if err != nil {
+		t.Fatalf("create meta part: %v", err)
+	}
+	if err := json.NewEncoder(metaPart).Encode(map[string]string{
+		"filename":    "note.txt",
+		"contentHash": "sha256=" + utils.HasherFor("sha256").Sum("a different payload entirely"),
+	}); err != nil {
+		t.Fatalf("encode meta part: %v", err)
+	}
+	latestPart, err := mw.CreateFormFile("latest", "note.txt")
+	if err != nil {
+		t.Fatalf("create latest part: %v", err)
+	}
+	if _, err := latestPart.Write([]byte("actual content")); err != nil {
+		t.Fatalf("write latest part: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("close multipart writer: %v", err)
+	}
+
+	httpReq, err := http.NewRequest("POST", ts.URL+"/sync", &body)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	httpReq.Header.Set("X-Sync-Key", s.Key)
+	httpReq.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	if _, err := os.Stat(filepath.Join(s.DataDir, "note.txt")); !os.IsNotExist(err) {
+		t.Errorf("a tampered upload should not have touched disk, stat err = %v", err)
+	}
+}
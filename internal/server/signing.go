@@ -0,0 +1,62 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"hsync/internal/protocol"
+	"os"
+	"strings"
+)
+
+// loadKeyring reads a keyring file of hex-encoded ed25519 public keys,
+// one per line, blank lines and "#"-prefixed comments ignored. This is
+// hsync's own keyring format, not a GPG or minisign one; see
+// protocol.SignatureArmorHeader for why.
+func loadKeyring(path string) ([]ed25519.PublicKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []ed25519.PublicKey
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "-----BEGIN PGP") || strings.HasPrefix(line, "untrusted comment:") || strings.HasPrefix(line, "RW") {
+			return nil, fmt.Errorf("keyring entry %q looks like a GPG or minisign public key, not a hex-encoded ed25519 one; hsync's keyring isn't interoperable with either, see SignatureArmorHeader", line)
+		}
+		raw, err := hex.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyring entry %q: %w", line, err)
+		}
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("keyring entry %q must decode to %d bytes", line, ed25519.PublicKeySize)
+		}
+		keys = append(keys, ed25519.PublicKey(raw))
+	}
+	return keys, scanner.Err()
+}
+
+// verifySignature checks armored against content using s's TrustedKeys,
+// returning an error describing why it was rejected.
+func (s *Server) verifySignature(content []byte, armored string) error {
+	if len(s.TrustedKeys) == 0 {
+		return fmt.Errorf("server has no trusted keys configured")
+	}
+	sig, err := protocol.DearmorSignature(armored)
+	if err != nil {
+		return fmt.Errorf("malformed signature: %w", err)
+	}
+	for _, key := range s.TrustedKeys {
+		if ed25519.Verify(key, content, sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature does not match any trusted key")
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadKeyringParsesHexKeysSkippingCommentsAndBlanks checks that
+// loadKeyring reads one hex-encoded ed25519 public key per line, in
+// order, skipping blank lines and "#" comments.
+func TestLoadKeyringParsesHexKeysSkippingCommentsAndBlanks(t *testing.T) {
+	pub1, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	pub2, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keyring")
+	contents := "# trusted keys\n\n" + hex.EncodeToString(pub1) + "\n" + hex.EncodeToString(pub2) + "\n"
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("write keyring: %v", err)
+	}
+
+	keys, err := loadKeyring(path)
+	if err != nil {
+		t.Fatalf("loadKeyring: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+	if !keys[0].Equal(pub1) || !keys[1].Equal(pub2) {
+		t.Error("loadKeyring didn't preserve key order/content")
+	}
+}
+
+// loadKeyringWithLine writes a single-line keyring file and loads it,
+// returning loadKeyring's error.
+func loadKeyringWithLine(t *testing.T, line string) error {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keyring")
+	if err := os.WriteFile(path, []byte(line+"\n"), 0644); err != nil {
+		t.Fatalf("write keyring: %v", err)
+	}
+	_, err := loadKeyring(path)
+	return err
+}
+
+// TestLoadKeyringRejectsGPGArmor checks that a GPG public-key armor
+// block is rejected with an explicit mismatch error, rather than the
+// generic "invalid keyring entry" hex-decode failure, since hsync's
+// keyring format isn't interoperable with GPG's.
+func TestLoadKeyringRejectsGPGArmor(t *testing.T) {
+	err := loadKeyringWithLine(t, "-----BEGIN PGP PUBLIC KEY BLOCK-----")
+	if err == nil {
+		t.Fatal("expected an error for a GPG-armored keyring entry")
+	}
+	if !strings.Contains(err.Error(), "GPG") {
+		t.Errorf("error = %q, want it to mention GPG", err)
+	}
+}
+
+// TestLoadKeyringRejectsMinisignCommentLine checks the same for a
+// minisign key file's "untrusted comment:" header line.
+func TestLoadKeyringRejectsMinisignCommentLine(t *testing.T) {
+	err := loadKeyringWithLine(t, "untrusted comment: minisign public key")
+	if err == nil {
+		t.Fatal("expected an error for a minisign comment line")
+	}
+	if !strings.Contains(err.Error(), "minisign") {
+		t.Errorf("error = %q, want it to mention minisign", err)
+	}
+}
+
+// TestLoadKeyringRejectsMinisignKeyLine checks the same for a raw
+// minisign public key line (base64 starting with its "RW" version
+// prefix), which can't be mistaken for hex either way.
+func TestLoadKeyringRejectsMinisignKeyLine(t *testing.T) {
+	err := loadKeyringWithLine(t, "RWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y91YGe")
+	if err == nil {
+		t.Fatal("expected an error for a minisign-formatted public key")
+	}
+	if !strings.Contains(err.Error(), "minisign") {
+		t.Errorf("error = %q, want it to mention minisign", err)
+	}
+}
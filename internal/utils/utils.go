@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// DefaultHashAlgo is the algorithm used when a caller doesn't negotiate
+// one explicitly.
+const DefaultHashAlgo = "sha256"
+
+// Hasher computes a named, hex-encoded digest of content.
+type Hasher interface {
+	Name() string
+	Sum(content string) string
+	// New returns a fresh hash.Hash for this algorithm, for callers
+	// streaming content too large to pass through Sum as a string (e.g.
+	// spooling an upload part to disk while hashing it in the same
+	// pass).
+	New() hash.Hash
+}
+
+type hashAlgo struct {
+	name string
+	new  func() hash.Hash
+}
+
+func (h hashAlgo) Name() string { return h.name }
+
+func (h hashAlgo) Sum(content string) string {
+	sum := h.new()
+	sum.Write([]byte(content))
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func (h hashAlgo) New() hash.Hash { return h.new() }
+
+var hashers = map[string]Hasher{
+	"sha256": hashAlgo{"sha256", sha256.New},
+	"sha512": hashAlgo{"sha512", sha512.New},
+	"blake2b-256": hashAlgo{"blake2b-256", func() hash.Hash {
+		h, _ := blake2b.New256(nil)
+		return h
+	}},
+}
+
+// HasherFor looks up a registered Hasher by algorithm name, falling back
+// to DefaultHashAlgo if name is empty or unrecognized.
+func HasherFor(name string) Hasher {
+	if h, ok := hashers[name]; ok {
+		return h
+	}
+	return hashers[DefaultHashAlgo]
+}
+
+// ParseContentHash splits an "X-Content-Hash: <algo>=<hex>" header value
+// into its algorithm and digest parts.
+func ParseContentHash(header string) (algo, digest string, ok bool) {
+	algo, digest, found := strings.Cut(header, "=")
+	if !found || algo == "" || digest == "" {
+		return "", "", false
+	}
+	return algo, digest, true
+}
+
+// CalculateHash returns the hex-encoded SHA-256 digest of content. Kept
+// for callers that don't need algorithm negotiation.
+func CalculateHash(content string) string {
+	return HasherFor(DefaultHashAlgo).Sum(content)
+}